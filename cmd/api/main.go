@@ -7,13 +7,19 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Profile timezones are validated even without system zoneinfo
 
 	"template/internal/auth"
 	"template/internal/config"
 	"template/internal/database"
+	"template/internal/device"
+	"template/internal/dpop"
 	"template/internal/email"
+	"template/internal/export"
+	"template/internal/preferences"
 	"template/internal/redis"
 	"template/internal/server"
+	"template/internal/storage"
 	"template/internal/telemetry"
 	"template/internal/user"
 	"template/internal/validator"
@@ -33,6 +39,12 @@ import (
 
 // @host localhost:8080
 // @BasePath /api/v1
+
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name Authorization
+
+// @securityDefinitions.basic BasicAuth
 func main() {
 	// 1. Load Config
 	cfg, err := config.Load()
@@ -62,18 +74,51 @@ func main() {
 	v := validator.New()
 
 	// 6. Init Repos & Services
+	fileStorage, storageHandler, err := newStorage(cfg.Storage)
+	if err != nil {
+		log.Fatalf("failed to init storage: %v", err)
+	}
+
+	preferencesRepo := preferences.NewRepository(db.GetDB())
+	preferencesService, err := preferences.NewService(preferencesRepo, redisClient, v, cfg.Preferences.Defaults, cfg.Preferences.CacheTTL)
+	if err != nil {
+		log.Fatalf("failed to init preferences: %v", err)
+	}
+
 	emailSender := email.NewSender(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Sender)
 	userRepo := user.NewRepository(db.GetDB())
-	userService := user.NewService(userRepo, cfg.JWTSecret, emailSender, cfg.FrontendHost)
+	userService := user.NewService(userRepo, cfg.JWTSecret, emailSender, cfg.FrontendHost, cfg.Password, cfg.Auth, fileStorage, preferencesService)
+
+	deviceService := device.NewService(redisClient, userService, cfg.FrontendHost)
+	dpopVerifier := dpop.NewVerifier(redisClient, cfg.Auth.DPoPProofMaxAge)
+
+	exportRegistry := export.NewRegistry()
+	user.RegisterExportSections(exportRegistry, userRepo, userService)
+	preferences.RegisterExportSections(exportRegistry, preferencesService)
+	exportService := export.NewService(exportRegistry, redisClient, cfg.Export.TTL)
 
 	// 7. Init Handlers
-	authHandler := auth.NewHandler(userService, v)
-	userHandler := user.NewHandler(userRepo)
+	profileCache := user.NewProfileCache(redisClient, cfg.Profiles.CacheTTL)
+	authHandler := auth.NewHandler(userService, v, dpopVerifier, cfg.Auth, profileCache)
+	deviceHandler := device.NewHandler(deviceService, v)
+	userHandler := user.NewHandler(userRepo, userService, v, profileCache)
+	exportHandler := export.NewHandler(exportService)
+	preferencesHandler := preferences.NewHandler(preferencesService)
 
 	// 8. Init Server
-	srv := server.NewServer(cfg, db, redisClient, authHandler, userHandler)
+	srv := server.NewServer(cfg, db, redisClient, dpopVerifier, authHandler, deviceHandler, userHandler, exportHandler, preferencesHandler, storageHandler, userService)
+
+	// 9. Start Background Jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
 
-	// 9. Start Server (Graceful Shutdown)
+	if cfg.Auth.Guest.Enabled {
+		go runPeriodically(jobsCtx, "guest cleanup", cfg.Auth.Guest.CleanupInterval, userService.CleanupGuests)
+	}
+	go runPeriodically(jobsCtx, "account purge", cfg.Auth.Deletion.PurgeInterval, userService.PurgeDeletedAccounts)
+	go userService.RunInviteWorker(jobsCtx)
+
+	// 10. Start Server (Graceful Shutdown)
 	go func() {
 		if err := srv.Start(); err != nil {
 			log.Printf("server error: %v", err)
@@ -83,6 +128,7 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -93,3 +139,50 @@ func main() {
 
 	log.Println("server exited properly")
 }
+
+// newStorage creates the configured storage driver. The handler serving signed
+// URLs is only needed, and only returned, for the local driver.
+func newStorage(cfg config.StorageConfig) (storage.Storage, *storage.Handler, error) {
+	if cfg.Driver == config.StorageS3 {
+		s3, err := storage.NewS3(storage.S3Options{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			UseSSL:          cfg.S3.UseSSL,
+			PathStyle:       cfg.S3.PathStyle,
+			PublicURL:       cfg.PublicURL,
+		})
+		return s3, nil, err
+	}
+
+	local, err := storage.NewLocal(cfg.LocalDir, cfg.PublicURL, cfg.PublicPrefixes, storage.NewSigner(cfg.SigningKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	return local, storage.NewHandler(local, cfg.MaxUploadBytes), nil
+}
+
+// runPeriodically calls job every interval until ctx is done, logging failures
+// and how many rows each run affected.
+func runPeriodically(ctx context.Context, name string, interval time.Duration, job func(context.Context) (int64, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := job(ctx)
+			if err != nil {
+				log.Printf("%s failed: %v", name, err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("%s: %d affected", name, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Command cli runs administrative tasks against the database configured for
+// the API.
+//
+//	cli import-users -format csv [-on-duplicate skip|update|fail] [-dry-run] [-send-invites] FILE
+//	cli export-users > users.ndjson
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"template/internal/config"
+	"template/internal/database"
+	"template/internal/email"
+	"template/internal/redis"
+	"template/internal/user"
+	"template/internal/validator"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db := database.New(cfg.DB.DSN)
+	defer db.Close()
+
+	// Storage and preferences are only used by login and avatar flows, which
+	// the CLI never runs
+	emailSender := email.NewSender(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Sender)
+	userService := user.NewService(user.NewRepository(db.GetDB()), cfg.JWTSecret, emailSender, cfg.FrontendHost, cfg.Password, cfg.Auth, nil, nil)
+
+	switch os.Args[1] {
+	case "import-users":
+		err = importUsers(ctx, userService, cfg, os.Args[2:])
+	case "export-users":
+		err = exportUsers(ctx, userService)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  cli import-users -format csv|ndjson [-on-duplicate skip|update|fail] [-dry-run] [-send-invites] FILE")
+	fmt.Fprintln(os.Stderr, "  cli export-users")
+	os.Exit(2)
+}
+
+func importUsers(ctx context.Context, userService user.Service, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("import-users", flag.ExitOnError)
+	var opts user.ImportOptions
+	flags.StringVar(&opts.Format, "format", "", "csv or ndjson; guessed from the file extension if omitted")
+	flags.StringVar(&opts.OnDuplicate, "on-duplicate", user.DuplicateSkip, "skip, update or fail")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "validate and report without importing")
+	flags.BoolVar(&opts.SendInvites, "send-invites", false, "email created users a link to set their password")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+	}
+	path := flags.Arg(0)
+	if opts.Format == "" {
+		opts.Format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+
+	v := validator.New()
+	if err := v.Validate(opts); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, rowErrors, err := user.ParseImport(f, opts.Format, v)
+	if err != nil {
+		return err
+	}
+
+	report, result, err := userService.ImportUsers(ctx, rows, rowErrors, &opts)
+	if err != nil {
+		return err
+	}
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	if err = out.Encode(report); err != nil {
+		return err
+	}
+
+	if !opts.DryRun && len(result.Updated) > 0 {
+		invalidateProfiles(ctx, cfg, result.Updated)
+	}
+
+	if report.Invited > 0 {
+		sent, inviteErr := userService.InviteImportedUsers(ctx, result.Created)
+		fmt.Fprintf(os.Stderr, "sent %d of %d invitations\n", sent, report.Invited)
+		if inviteErr != nil {
+			return inviteErr
+		}
+	}
+
+	return nil
+}
+
+// invalidateProfiles drops the cached public profiles of updated users. Without
+// Redis they are only served stale until PROFILE_CACHE_TTL passes, so that is
+// not an error.
+func invalidateProfiles(ctx context.Context, cfg *config.Config, users []user.ImportedUser) {
+	redisClient := redis.New(cfg.Redis.Addr)
+	defer func() { _ = redisClient.Client.Close() }()
+
+	if err := redisClient.Client.Ping(ctx).Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "could not reach redis, updated profiles may be served from cache for %s: %v\n", cfg.Profiles.CacheTTL, err)
+		return
+	}
+
+	profiles := user.NewProfileCache(redisClient, cfg.Profiles.CacheTTL)
+	for _, u := range users {
+		profiles.Invalidate(ctx, u.ID, u.Username)
+	}
+}
+
+func exportUsers(ctx context.Context, userService user.Service) error {
+	w := bufio.NewWriter(os.Stdout)
+	if err := userService.ExportUsers(ctx, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
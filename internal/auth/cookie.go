@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"template/internal/jwt"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// RefreshCookieName holds the refresh token in cookie mode.
+	RefreshCookieName = "refresh_token"
+	// CSRFCookieName holds the double-submit CSRF token. It is readable by
+	// JavaScript so the client can echo it in the X-CSRF-Token header.
+	CSRFCookieName = "csrf_token"
+
+	refreshCookiePath = "/api/v1/auth"
+)
+
+// setSessionCookies moves the refresh token out of the response body into an
+// HttpOnly cookie and issues a fresh CSRF token alongside it.
+func (h *Handler) setSessionCookies(c echo.Context, tokens *jwt.TokenPair) error {
+	csrfToken, err := generateCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	// Cookies live exactly as long as the refresh token
+	maxAge := time.Duration(tokens.RefreshExpiresIn) * time.Second
+	c.SetCookie(h.newCookie(RefreshCookieName, tokens.RefreshToken, refreshCookiePath, true, maxAge))
+	c.SetCookie(h.newCookie(CSRFCookieName, csrfToken, "/", false, maxAge))
+	tokens.RefreshToken = ""
+
+	return nil
+}
+
+func (h *Handler) clearSessionCookies(c echo.Context) {
+	c.SetCookie(h.newCookie(RefreshCookieName, "", refreshCookiePath, true, -1))
+	c.SetCookie(h.newCookie(CSRFCookieName, "", "/", false, -1))
+}
+
+func (h *Handler) newCookie(name, value, path string, httpOnly bool, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		HttpOnly: httpOnly,
+		Secure:   h.authConfig.CookieSecure,
+		SameSite: parseSameSite(h.authConfig.CookieSameSite),
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+func parseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
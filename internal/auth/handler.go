@@ -1,9 +1,14 @@
 package auth
 
 import (
+	"errors"
 	"net/http"
 
+	"template/internal/config"
+	"template/internal/dpop"
 	"template/internal/json"
+	"template/internal/jwt"
+	customMiddleware "template/internal/middleware"
 	"template/internal/response"
 	"template/internal/user"
 	"template/internal/validator"
@@ -14,21 +19,66 @@ import (
 type Handler struct {
 	userService user.Service
 	validator   *validator.Validator
+	dpop        *dpop.Verifier
+	authConfig  config.AuthConfig
+	profiles    *user.ProfileCache
 }
 
-func NewHandler(userService user.Service, validator *validator.Validator) *Handler {
+func NewHandler(userService user.Service, validator *validator.Validator, dpopVerifier *dpop.Verifier, authConfig config.AuthConfig, profiles *user.ProfileCache) *Handler {
 	return &Handler{
 		userService: userService,
 		validator:   validator,
+		dpop:        dpopVerifier,
+		authConfig:  authConfig,
+		profiles:    profiles,
 	}
 }
 
 func (h *Handler) RegisterRoutes(g *echo.Group) {
 	g.POST("/auth/register", h.Register)
+	g.POST("/auth/guest", h.Guest)
 	g.POST("/auth/login", h.Login)
 	g.POST("/auth/refresh", h.RefreshToken)
+	g.POST("/auth/logout", h.Logout)
 	g.POST("/auth/recover-password", h.RecoverPassword)
 	g.POST("/auth/reset-password", h.ResetPassword)
+	g.POST("/auth/confirm-email", h.ConfirmEmail)
+	g.POST("/auth/report-session", h.ReportSession)
+	g.POST("/auth/cancel-deletion", h.CancelDeletion)
+
+	// For confidential clients such as the API gateway
+	clientAuth := customMiddleware.ClientCredentials(h.authConfig.Clients)
+	g.POST("/auth/introspect", h.Introspect, clientAuth)
+	g.POST("/auth/revoke", h.Revoke, clientAuth)
+}
+
+// clientInfo describes the caller of a token-issuing endpoint. If a DPoP proof is
+// sent it must be valid, and the issued tokens are bound to its key.
+func (h *Handler) clientInfo(c echo.Context) (user.ClientInfo, error) {
+	client := user.ClientInfo{
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+
+	proof := c.Request().Header.Get(dpop.HeaderName)
+	if proof == "" {
+		return client, nil
+	}
+
+	jkt, err := h.dpop.Verify(c.Request().Context(), proof, c.Request().Method, dpop.RequestURL(c.Request(), c.Scheme()), "")
+	if err != nil {
+		return client, err
+	}
+
+	client.JKT = jkt
+	return client, nil
+}
+
+func invalidDPoPProof(c echo.Context, err error) error {
+	if err == dpop.ErrInvalidProof || err == dpop.ErrReplayedProof {
+		return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_DPOP_PROOF", "Invalid DPoP proof", nil)
+	}
+	return json.InternalServerError(c, err)
 }
 
 // Register godoc
@@ -39,7 +89,9 @@ func (h *Handler) RegisterRoutes(g *echo.Group) {
 // @Produce json
 // @Param request body user.RegisterRequest true "Register Request"
 // @Success 201 {object} response.Response{data=jwt.TokenPair}
+// @Success 202 {object} response.Response
 // @Failure 400 {object} response.Response
+// @Failure 403 {object} response.Response
 // @Failure 409 {object} response.Response
 // @Failure 500 {object} response.Response
 // @Router /auth/register [post]
@@ -53,15 +105,83 @@ func (h *Handler) Register(c echo.Context) error {
 		return json.BadRequest(c, err)
 	}
 
-	tokens, err := h.userService.Register(c.Request().Context(), &req)
+	client, err := h.clientInfo(c)
+	if err != nil {
+		return invalidDPoPProof(c, err)
+	}
+
+	tokens, err := h.userService.Register(c.Request().Context(), &req, client)
 	if err != nil {
 		if err == user.ErrUserAlreadyExists {
 			return response.ErrorJSON(c, http.StatusConflict, "USER_ALREADY_EXISTS", "User with this email already exists", nil)
 		}
+		if err == user.ErrUsernameTaken {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_TAKEN", "Username is already taken", nil)
+		}
+		if err == user.ErrUsernameConfusable {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_CONFUSABLE", "Username is too similar to an existing username", nil)
+		}
+		if err == user.ErrUsernameReserved {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_RESERVED", "Username is reserved", nil)
+		}
+		if err == user.ErrInvalidUsername {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_USERNAME", "Username must be 3 to 50 letters of one script, digits, '_', '-' or '.'", nil)
+		}
+		if err == user.ErrConsentRequired {
+			return response.ErrorJSON(c, http.StatusBadRequest, "CONSENT_REQUIRED", "You must accept the current terms and privacy policy", nil)
+		}
+		if err == user.ErrUnknownDocument {
+			return response.ErrorJSON(c, http.StatusBadRequest, "UNKNOWN_DOCUMENT", "One or more documents do not exist or are not published", nil)
+		}
+		if err == user.ErrInviteRequired {
+			return response.ErrorJSON(c, http.StatusForbidden, "INVITE_REQUIRED", "Registration requires an invite code", nil)
+		}
+		if err == user.ErrInvalidInvite {
+			return response.ErrorJSON(c, http.StatusForbidden, "INVALID_INVITE", "Invite code is invalid, expired or used up", nil)
+		}
+		if err == user.ErrEmailDomainNotAllowed {
+			return response.ErrorJSON(c, http.StatusForbidden, "EMAIL_DOMAIN_NOT_ALLOWED", "Registration is not open to this email domain", nil)
+		}
+		if err == user.ErrDisposableEmail {
+			return response.ErrorJSON(c, http.StatusForbidden, "DISPOSABLE_EMAIL_NOT_ALLOWED", "Disposable email addresses are not allowed", nil)
+		}
 		return json.InternalServerError(c, err)
 	}
 
-	return response.JSON(c, http.StatusCreated, tokens, nil)
+	// Enumeration protection: same response whether or not the account existed
+	if tokens == nil {
+		return response.JSON(c, http.StatusAccepted, map[string]string{"message": "Check your email to continue."}, nil)
+	}
+
+	return h.tokenResponse(c, http.StatusCreated, tokens)
+}
+
+// Guest godoc
+// @Summary Start a guest session
+// @Description Create an anonymous account with no email or password. It can later be upgraded via /users/me/upgrade, and is deleted once inactive for too long.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Success 201 {object} response.Response{data=jwt.TokenPair}
+// @Failure 400 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/guest [post]
+func (h *Handler) Guest(c echo.Context) error {
+	client, err := h.clientInfo(c)
+	if err != nil {
+		return invalidDPoPProof(c, err)
+	}
+
+	tokens, err := h.userService.CreateGuest(c.Request().Context(), client)
+	if err != nil {
+		if err == user.ErrGuestsDisabled {
+			return response.ErrorJSON(c, http.StatusForbidden, "GUESTS_DISABLED", "Guest accounts are disabled", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return h.tokenResponse(c, http.StatusCreated, tokens)
 }
 
 // Login godoc
@@ -74,6 +194,7 @@ func (h *Handler) Register(c echo.Context) error {
 // @Success 200 {object} response.Response{data=jwt.TokenPair}
 // @Failure 400 {object} response.Response
 // @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
 // @Failure 500 {object} response.Response
 // @Router /auth/login [post]
 func (h *Handler) Login(c echo.Context) error {
@@ -86,24 +207,65 @@ func (h *Handler) Login(c echo.Context) error {
 		return json.BadRequest(c, err)
 	}
 
-	tokens, err := h.userService.Login(c.Request().Context(), &req)
+	client, err := h.clientInfo(c)
+	if err != nil {
+		return invalidDPoPProof(c, err)
+	}
+
+	tokens, err := h.userService.Login(c.Request().Context(), &req, client)
 	if err != nil {
 		if err == user.ErrInvalidCredentials {
 			return json.Unauthorized(c, "Invalid credentials")
 		}
+		if err == user.ErrPasswordExpired {
+			return response.ErrorJSON(c, http.StatusForbidden, "PASSWORD_EXPIRED", "Password must be reset, a reset link has been sent to your email", nil)
+		}
+		if err == user.ErrAccountSuspended {
+			return response.ErrorJSON(c, http.StatusForbidden, "ACCOUNT_SUSPENDED", "This account has been suspended", nil)
+		}
+		if err == user.ErrAccountPendingDeletion {
+			return response.ErrorJSON(c, http.StatusForbidden, "ACCOUNT_PENDING_DELETION", "This account is scheduled for deletion, a link to restore it has been sent to your email", nil)
+		}
 		return json.InternalServerError(c, err)
 	}
 
-	return response.JSON(c, http.StatusOK, tokens, nil)
+	return h.tokenResponse(c, http.StatusOK, tokens)
+}
+
+// tokenResponse writes issued tokens, moving the refresh token into a cookie in
+// cookie mode.
+func (h *Handler) tokenResponse(c echo.Context, status int, tokens *jwt.TokenPair) error {
+	if h.authConfig.CookieMode {
+		if err := h.setSessionCookies(c, tokens); err != nil {
+			return json.InternalServerError(c, err)
+		}
+	}
+
+	return response.JSON(c, status, tokens, nil)
+}
+
+// refreshTokenFrom returns the refresh token from the request body, falling back
+// to the session cookie in cookie mode.
+func (h *Handler) refreshTokenFrom(c echo.Context, fromBody string) string {
+	if fromBody != "" || !h.authConfig.CookieMode {
+		return fromBody
+	}
+
+	cookie, err := c.Cookie(RefreshCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
 }
 
 type RefreshRequest struct {
-	RefreshToken string `json:"refresh_token" validate:"required"`
+	// RefreshToken may be omitted in cookie mode, where it is read from the cookie.
+	RefreshToken string `json:"refresh_token"`
 }
 
 // RefreshToken godoc
 // @Summary Refresh access token
-// @Description Use a valid refresh token to get a new access token
+// @Description Use a valid refresh token to get a new access token. In cookie mode the token is read from the refresh_token cookie.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -111,6 +273,7 @@ type RefreshRequest struct {
 // @Success 200 {object} response.Response{data=jwt.TokenPair}
 // @Failure 400 {object} response.Response
 // @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
 // @Failure 500 {object} response.Response
 // @Router /auth/refresh [post]
 func (h *Handler) RefreshToken(c echo.Context) error {
@@ -119,19 +282,68 @@ func (h *Handler) RefreshToken(c echo.Context) error {
 		return json.BadRequest(c, err)
 	}
 
-	if err := h.validator.Validate(req); err != nil {
-		return json.BadRequest(c, err)
+	refreshToken := h.refreshTokenFrom(c, req.RefreshToken)
+	if refreshToken == "" {
+		return json.BadRequest(c, errors.New("refresh_token is required"))
 	}
 
-	tokens, err := h.userService.RefreshToken(c.Request().Context(), req.RefreshToken)
+	client, err := h.clientInfo(c)
+	if err != nil {
+		return invalidDPoPProof(c, err)
+	}
+
+	tokens, err := h.userService.RefreshToken(c.Request().Context(), refreshToken, client)
 	if err != nil {
 		if err == user.ErrInvalidToken {
+			if h.authConfig.CookieMode {
+				h.clearSessionCookies(c)
+			}
 			return json.Unauthorized(c, "Invalid or expired refresh token")
 		}
+		if err == user.ErrPasswordExpired {
+			return response.ErrorJSON(c, http.StatusForbidden, "PASSWORD_EXPIRED", "Password must be reset before signing in again", nil)
+		}
+		if err == user.ErrAccountSuspended {
+			return response.ErrorJSON(c, http.StatusForbidden, "ACCOUNT_SUSPENDED", "This account has been suspended", nil)
+		}
 		return json.InternalServerError(c, err)
 	}
 
-	return response.JSON(c, http.StatusOK, tokens, nil)
+	return h.tokenResponse(c, http.StatusOK, tokens)
+}
+
+// Logout godoc
+// @Summary Logout
+// @Description Revoke the refresh token (from the body or, in cookie mode, the cookie) and clear session cookies
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body RefreshRequest false "Logout Request"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/logout [post]
+func (h *Handler) Logout(c echo.Context) error {
+	var req RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	refreshToken := h.refreshTokenFrom(c, req.RefreshToken)
+	if refreshToken == "" {
+		return json.BadRequest(c, errors.New("refresh_token is required"))
+	}
+
+	err := h.userService.Logout(c.Request().Context(), refreshToken)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	if h.authConfig.CookieMode {
+		h.clearSessionCookies(c)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Logged out"}, nil)
 }
 
 type RecoverPasswordRequest struct {
@@ -199,8 +411,194 @@ func (h *Handler) ResetPassword(c echo.Context) error {
 		if err == user.ErrInvalidToken {
 			return json.Unauthorized(c, "Invalid or expired token")
 		}
+		if err == user.ErrPasswordReused {
+			return response.ErrorJSON(c, http.StatusBadRequest, "PASSWORD_REUSED", "New password must not match a recently used password", nil)
+		}
 		return json.InternalServerError(c, err)
 	}
 
 	return response.JSON(c, http.StatusOK, map[string]string{"message": "Password updated successfully"}, nil)
 }
+
+type ConfirmEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ConfirmEmail godoc
+// @Summary Confirm email change
+// @Description Apply a pending email change using the token sent to the new address
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ConfirmEmailRequest true "Confirm Email Request"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/confirm-email [post]
+func (h *Handler) ConfirmEmail(c echo.Context) error {
+	var req ConfirmEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	err := h.userService.ConfirmEmailChange(c.Request().Context(), req.Token)
+	if err != nil {
+		if err == user.ErrInvalidToken {
+			return json.Unauthorized(c, "Invalid or expired token")
+		}
+		if err == user.ErrUserAlreadyExists {
+			return response.ErrorJSON(c, http.StatusConflict, "USER_ALREADY_EXISTS", "User with this email already exists", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Email updated successfully"}, nil)
+}
+
+type ReportSessionRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ReportSession godoc
+// @Summary Report an unrecognized sign-in
+// @Description Sign out every session of the account from a new-device alert and require a password reset
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body ReportSessionRequest true "Report Session Request"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/report-session [post]
+func (h *Handler) ReportSession(c echo.Context) error {
+	var req ReportSessionRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	err := h.userService.ReportSession(c.Request().Context(), req.Token)
+	if err != nil {
+		if err == user.ErrInvalidToken {
+			return json.Unauthorized(c, "Invalid or expired token")
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "All sessions have been signed out. Check your email to reset your password."}, nil)
+}
+
+type TokenRequest struct {
+	Token         string `json:"token" form:"token" validate:"required"`
+	TokenTypeHint string `json:"token_type_hint" form:"token_type_hint" validate:"omitempty,oneof=access_token refresh_token"`
+}
+
+// Introspect godoc
+// @Summary Introspect a token
+// @Description Report whether an access or refresh token is active (RFC 7662). Requires client credentials via HTTP Basic auth. The result is not wrapped in the usual envelope.
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce json
+// @Security BasicAuth
+// @Param request body TokenRequest true "Introspection Request"
+// @Success 200 {object} user.TokenIntrospection
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/introspect [post]
+func (h *Handler) Introspect(c echo.Context) error {
+	var req TokenRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	result, err := h.userService.IntrospectToken(c.Request().Context(), req.Token, req.TokenTypeHint)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// Revoke godoc
+// @Summary Revoke a token
+// @Description Revoke the session of an access or refresh token (RFC 7009). Unknown tokens are ignored. Requires client credentials via HTTP Basic auth. Success is an empty 200 response.
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce json
+// @Security BasicAuth
+// @Param request body TokenRequest true "Revocation Request"
+// @Success 200 "Token revoked"
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/revoke [post]
+func (h *Handler) Revoke(c echo.Context) error {
+	var req TokenRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	err := h.userService.RevokeToken(c.Request().Context(), req.Token, req.TokenTypeHint)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+type CancelDeletionRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// CancelDeletion godoc
+// @Summary Cancel account deletion
+// @Description Restore an account scheduled for deletion using the token from the email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body CancelDeletionRequest true "Cancel Deletion Request"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/cancel-deletion [post]
+func (h *Handler) CancelDeletion(c echo.Context) error {
+	var req CancelDeletionRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	restored, err := h.userService.CancelDeletion(c.Request().Context(), req.Token)
+	if err != nil {
+		if err == user.ErrInvalidToken {
+			return json.Unauthorized(c, "Invalid or expired token")
+		}
+		return json.InternalServerError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), restored.ID, restored.Username)
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Account deletion cancelled. You can log in again."}, nil)
+}
@@ -1,8 +1,13 @@
 package config
 
 import (
+	"fmt"
+	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 )
@@ -13,9 +18,21 @@ type Config struct {
 	DB           DBConfig
 	Redis        RedisConfig
 	SMTP         SMTPConfig
+	Password     PasswordConfig
+	Auth         AuthConfig
+	Export       ExportConfig
+	Profiles     ProfilesConfig
+	Storage      StorageConfig
+	Preferences  PreferencesConfig
 	JWTSecret    string
 	Domain       string
 	FrontendHost string
+	// TrustedProxies are the networks whose X-Forwarded-For header is believed
+	// when determining the client IP. Without any, the connection's address is used.
+	TrustedProxies []*net.IPNet
+	// AllowedOrigins are the browser origins allowed by CORS. Credentialed
+	// requests, which cookie mode relies on, need them listed explicitly.
+	AllowedOrigins []string
 }
 
 type SMTPConfig struct {
@@ -26,6 +43,157 @@ type SMTPConfig struct {
 	Sender   string
 }
 
+type PasswordConfig struct {
+	// HistorySize is the number of previous passwords a user may not reuse.
+	HistorySize int
+	// MaxAge forces a password change at the next login once exceeded. Zero disables it.
+	MaxAge time.Duration
+}
+
+type AuthConfig struct {
+	// EnumerationProtection makes registration respond identically whether or
+	// not the email is taken, notifying the existing owner by email instead.
+	EnumerationProtection bool
+	// DPoPProofMaxAge is how far a DPoP proof's iat may be from the server time.
+	DPoPProofMaxAge time.Duration
+	// CookieMode delivers refresh tokens in an HttpOnly cookie instead of the
+	// response body and enables CSRF protection for browser clients.
+	CookieMode     bool
+	CookieSecure   bool
+	CookieSameSite string
+	// Clients maps client IDs to secrets for token introspection and revocation.
+	Clients map[string]string
+
+	AccessTokenTTL        time.Duration
+	ResetTokenTTL         time.Duration
+	EmailChangeTokenTTL   time.Duration
+	SessionReportTokenTTL time.Duration
+	// ImportInviteTokenTTL is how long the set-password link emailed to users
+	// created by a bulk import stays valid. Afterwards they use password
+	// recovery.
+	ImportInviteTokenTTL time.Duration
+	// Session applies to regular logins, RememberMeSession to logins with
+	// "remember me" checked.
+	Session           SessionPolicy
+	RememberMeSession SessionPolicy
+
+	Registration RegistrationConfig
+	Guest        GuestConfig
+	Deletion     DeletionConfig
+}
+
+type DeletionConfig struct {
+	// GracePeriod is how long a deleted account can still be restored with the
+	// emailed cancellation link before it is purged.
+	GracePeriod time.Duration
+	// CancelTokenTTL is how long one cancellation link stays valid. Logging in
+	// during the grace period emails a new one.
+	CancelTokenTTL time.Duration
+	// PurgeInterval is how often accounts past their grace period are purged.
+	PurgeInterval time.Duration
+}
+
+type GuestConfig struct {
+	// Enabled allows anonymous guest accounts via /auth/guest.
+	Enabled bool
+	// InactiveTTL is how long a guest may go without refreshing before the
+	// account and its data are deleted.
+	InactiveTTL time.Duration
+	// CleanupInterval is how often abandoned guests are purged.
+	CleanupInterval time.Duration
+}
+
+// Registration modes.
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationDomain = "domain"
+)
+
+type RegistrationConfig struct {
+	// Mode is one of RegistrationOpen, RegistrationInvite or RegistrationDomain.
+	Mode string
+	// AllowedDomains are the only email domains accepted in domain mode.
+	AllowedDomains []string
+	// BlockDisposable rejects known disposable email domains in every mode.
+	BlockDisposable bool
+	// BlockedDomains extends the built-in disposable domain list.
+	BlockedDomains []string
+	// ReservedUsernames extends the built-in list of usernames users cannot
+	// choose, at registration or later.
+	ReservedUsernames []string
+}
+
+// SessionPolicy bounds how long a session (a chain of rotated refresh tokens)
+// lives.
+type SessionPolicy struct {
+	// IdleTimeout expires the session when it is not refreshed for this long.
+	// Every refresh slides the window forward.
+	IdleTimeout time.Duration
+	// AbsoluteLifetime caps the session from login regardless of activity.
+	AbsoluteLifetime time.Duration
+}
+
+type ExportConfig struct {
+	// TTL is how long a finished personal data export can be downloaded.
+	TTL time.Duration
+}
+
+type ProfilesConfig struct {
+	// CacheTTL is how long public profile lookups are cached, including misses.
+	CacheTTL time.Duration
+	// RateLimit is the number of profile lookups allowed per IP and minute, on
+	// top of the global limit.
+	RateLimit int
+}
+
+type PreferencesConfig struct {
+	// Defaults is a partial preferences document, as JSON, overriding the
+	// built-in defaults for users who have not changed a setting themselves.
+	Defaults string
+	// CacheTTL is how long a user's preferences are cached.
+	CacheTTL time.Duration
+}
+
+// Storage drivers.
+const (
+	StorageLocal = "local"
+	StorageS3    = "s3"
+)
+
+// weakSigningKeys are publicly known values, such as the .env.example
+// placeholder, that STORAGE_SIGNING_KEY may not be left at.
+var weakSigningKeys = []string{"secret", "change-me"}
+
+type StorageConfig struct {
+	Driver string
+	// LocalDir is where the local driver stores files. They are served by the
+	// API under /api/v1/files.
+	LocalDir string
+	// PublicURL is the base URL objects under PublicPrefixes are fetched from.
+	// With S3 it may be left empty to hand out presigned URLs instead.
+	PublicURL string
+	// PublicPrefixes are key prefixes anyone may read without a signed URL.
+	PublicPrefixes []string
+	// SigningKey signs expiring download and upload URLs for the local driver.
+	// Load refuses to start that driver without one.
+	SigningKey string
+	// MaxUploadBytes caps uploads through signed URLs of the local driver.
+	MaxUploadBytes int64
+	S3             S3Config
+}
+
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	// PathStyle addresses buckets as endpoint/bucket, which MinIO requires.
+	PathStyle bool
+}
+
 type DBConfig struct {
 	DSN string
 }
@@ -41,6 +209,42 @@ func Load() (*Config, error) {
 		port = 8080 // Default
 	}
 
+	registrationMode := getEnv("REGISTRATION_MODE", RegistrationOpen)
+	switch registrationMode {
+	case RegistrationOpen, RegistrationInvite, RegistrationDomain:
+	default:
+		return nil, fmt.Errorf("invalid REGISTRATION_MODE %q", registrationMode)
+	}
+
+	storageDriver := getEnv("STORAGE_DRIVER", StorageLocal)
+	switch storageDriver {
+	case StorageLocal, StorageS3:
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_DRIVER %q", storageDriver)
+	}
+
+	// Anyone knowing the key can sign URLs for any stored file
+	signingKey := getEnv("STORAGE_SIGNING_KEY", "")
+	if storageDriver == StorageLocal && (signingKey == "" || slices.Contains(weakSigningKeys, signingKey)) {
+		return nil, fmt.Errorf("STORAGE_SIGNING_KEY must be set to a random secret for the local storage driver")
+	}
+
+	publicPrefixes := getEnvAsSlice("STORAGE_PUBLIC_PREFIXES")
+	if publicPrefixes == nil {
+		publicPrefixes = []string{"avatars/"}
+	}
+
+	trustedProxies, err := getEnvAsNetworks("TRUSTED_PROXIES")
+	if err != nil {
+		return nil, err
+	}
+
+	cookieMode := getEnvAsBool("AUTH_COOKIE_MODE", false)
+	allowedOrigins := getEnvAsSlice("CORS_ALLOWED_ORIGINS")
+	if cookieMode && (len(allowedOrigins) == 0 || slices.Contains(allowedOrigins, "*")) {
+		return nil, fmt.Errorf("AUTH_COOKIE_MODE requires CORS_ALLOWED_ORIGINS to list the frontend origins")
+	}
+
 	return &Config{
 		Port:   port,
 		AppEnv: getEnv("APP_ENV", "dev"),
@@ -57,9 +261,81 @@ func Load() (*Config, error) {
 			Password: getEnv("SMTP_PASSWORD", ""),
 			Sender:   getEnv("SMTP_SENDER", "noreply@example.com"),
 		},
-		JWTSecret:    getEnv("JWT_SECRET", "secret"),
-		Domain:       getEnv("DOMAIN", "localhost"),
-		FrontendHost: getEnv("FRONTEND_HOST", "http://localhost:5173"),
+		Password: PasswordConfig{
+			HistorySize: getEnvAsInt("PASSWORD_HISTORY_SIZE", 5),
+			MaxAge:      time.Duration(getEnvAsInt("PASSWORD_MAX_AGE_DAYS", 0)) * 24 * time.Hour,
+		},
+		Auth: AuthConfig{
+			EnumerationProtection: getEnvAsBool("AUTH_ENUMERATION_PROTECTION", false),
+			DPoPProofMaxAge:       time.Duration(getEnvAsInt("AUTH_DPOP_PROOF_MAX_AGE_SECONDS", 60)) * time.Second,
+			CookieMode:            cookieMode,
+			CookieSecure:          getEnvAsBool("AUTH_COOKIE_SECURE", true),
+			CookieSameSite:        getEnv("AUTH_COOKIE_SAMESITE", "strict"),
+			Clients:               getEnvAsMap("AUTH_CLIENTS"),
+			AccessTokenTTL:        getEnvAsDuration("JWT_ACCESS_TOKEN_TTL", 15*time.Minute),
+			ResetTokenTTL:         getEnvAsDuration("JWT_RESET_TOKEN_TTL", 1*time.Hour),
+			EmailChangeTokenTTL:   getEnvAsDuration("JWT_EMAIL_CHANGE_TOKEN_TTL", 24*time.Hour),
+			SessionReportTokenTTL: getEnvAsDuration("JWT_SESSION_REPORT_TOKEN_TTL", 7*24*time.Hour),
+			ImportInviteTokenTTL:  getEnvAsDuration("JWT_IMPORT_INVITE_TOKEN_TTL", 72*time.Hour),
+			Session: SessionPolicy{
+				IdleTimeout:      getEnvAsDuration("SESSION_IDLE_TIMEOUT", 24*time.Hour),
+				AbsoluteLifetime: getEnvAsDuration("SESSION_ABSOLUTE_LIFETIME", 7*24*time.Hour),
+			},
+			RememberMeSession: SessionPolicy{
+				IdleTimeout:      getEnvAsDuration("SESSION_REMEMBER_ME_IDLE_TIMEOUT", 30*24*time.Hour),
+				AbsoluteLifetime: getEnvAsDuration("SESSION_REMEMBER_ME_ABSOLUTE_LIFETIME", 90*24*time.Hour),
+			},
+			Registration: RegistrationConfig{
+				Mode:              registrationMode,
+				AllowedDomains:    getEnvAsSlice("REGISTRATION_ALLOWED_DOMAINS"),
+				BlockDisposable:   getEnvAsBool("REGISTRATION_BLOCK_DISPOSABLE", true),
+				BlockedDomains:    getEnvAsSlice("REGISTRATION_BLOCKED_DOMAINS"),
+				ReservedUsernames: getEnvAsSlice("REGISTRATION_RESERVED_USERNAMES"),
+			},
+			Guest: GuestConfig{
+				Enabled:         getEnvAsBool("GUEST_ACCOUNTS_ENABLED", false),
+				InactiveTTL:     getEnvAsDuration("GUEST_INACTIVE_TTL", 30*24*time.Hour),
+				CleanupInterval: getEnvAsDuration("GUEST_CLEANUP_INTERVAL", time.Hour),
+			},
+			Deletion: DeletionConfig{
+				GracePeriod:    getEnvAsDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour),
+				CancelTokenTTL: getEnvAsDuration("ACCOUNT_DELETION_CANCEL_TOKEN_TTL", 24*time.Hour),
+				PurgeInterval:  getEnvAsDuration("ACCOUNT_PURGE_INTERVAL", time.Hour),
+			},
+		},
+		Export: ExportConfig{
+			TTL: getEnvAsDuration("EXPORT_TTL", 24*time.Hour),
+		},
+		Profiles: ProfilesConfig{
+			CacheTTL:  getEnvAsDuration("PROFILE_CACHE_TTL", time.Minute),
+			RateLimit: getEnvAsInt("PROFILE_RATE_LIMIT", 30),
+		},
+		Storage: StorageConfig{
+			Driver:         storageDriver,
+			LocalDir:       getEnv("STORAGE_LOCAL_DIR", "./uploads"),
+			PublicURL:      getEnv("STORAGE_PUBLIC_URL", "http://localhost:8080/api/v1/files"),
+			PublicPrefixes: publicPrefixes,
+			SigningKey:     signingKey,
+			MaxUploadBytes: int64(getEnvAsInt("STORAGE_MAX_UPLOAD_BYTES", 100<<20)),
+			S3: S3Config{
+				Endpoint:        getEnv("S3_ENDPOINT", "localhost:9000"),
+				Region:          getEnv("S3_REGION", "us-east-1"),
+				Bucket:          getEnv("S3_BUCKET", "uploads"),
+				AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+				UseSSL:          getEnvAsBool("S3_USE_SSL", false),
+				PathStyle:       getEnvAsBool("S3_PATH_STYLE", true),
+			},
+		},
+		Preferences: PreferencesConfig{
+			Defaults: getEnv("PREFERENCES_DEFAULTS", ""),
+			CacheTTL: getEnvAsDuration("PREFERENCES_CACHE_TTL", 5*time.Minute),
+		},
+		JWTSecret:      getEnv("JWT_SECRET", "secret"),
+		Domain:         getEnv("DOMAIN", "localhost"),
+		FrontendHost:   getEnv("FRONTEND_HOST", "http://localhost:5173"),
+		TrustedProxies: trustedProxies,
+		AllowedOrigins: allowedOrigins,
 	}, nil
 }
 
@@ -78,3 +354,72 @@ func getEnvAsInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// getEnvAsDuration parses Go duration strings such as "15m" or "168h".
+func getEnvAsDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// getEnvAsSlice parses a comma-separated list, dropping empty entries.
+func getEnvAsSlice(key string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvAsMap parses "key1:value1,key2:value2". Malformed pairs are skipped.
+func getEnvAsMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && k != "" && v != "" {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// getEnvAsNetworks parses a comma-separated list of CIDRs. A bare IP stands for
+// a network of that single address.
+func getEnvAsNetworks(key string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, item := range getEnvAsSlice(key) {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s entry %q", key, item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q", key, item)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
@@ -0,0 +1,82 @@
+// Package device implements the OAuth 2.0 device authorization grant (RFC 8628)
+// for clients that cannot open a browser, such as CLIs and TVs.
+package device
+
+import (
+	"errors"
+	"time"
+)
+
+// GrantType is the grant_type value clients must send when polling for tokens.
+const GrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	codeTTL         = 10 * time.Minute
+	pollInterval    = 5 * time.Second
+	slowDownPenalty = 5 * time.Second
+
+	// userCodeAttempts bounds the retries when a random user code is taken.
+	userCodeAttempts = 5
+	// approveAttempts bounds the retries when a poll changes the state while
+	// it is being approved.
+	approveAttempts = 3
+)
+
+// Polling errors, named after their RFC 8628 error codes.
+var (
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+	ErrInvalidUserCode      = errors.New("invalid user code")
+
+	errNoFreeUserCode = errors.New("no free user code")
+)
+
+// Authorization statuses.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+)
+
+// Authorization is the server-side state of one device code, kept in Redis.
+type Authorization struct {
+	ClientID   string    `json:"client_id"`
+	UserCode   string    `json:"user_code"`
+	Status     string    `json:"status"`
+	UserID     string    `json:"user_id,omitempty"`
+	Interval   int       `json:"interval"`
+	LastPolled time.Time `json:"last_polled"`
+}
+
+type CodeRequest struct {
+	ClientID string `json:"client_id" form:"client_id"`
+}
+
+type CodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type TokenRequest struct {
+	GrantType  string `json:"grant_type" form:"grant_type" validate:"required,eq=urn:ietf:params:oauth:grant-type:device_code"`
+	DeviceCode string `json:"device_code" form:"device_code" validate:"required"`
+}
+
+// TokenError is the error body of the code and token endpoints, in the shape
+// OAuth clients expect (RFC 6749 section 5.2).
+type TokenError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type ApproveRequest struct {
+	UserCode string `json:"user_code" validate:"required"`
+	// Deny rejects the request instead of approving it.
+	Deny bool `json:"deny"`
+}
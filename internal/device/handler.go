@@ -0,0 +1,155 @@
+package device
+
+import (
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/jwt"
+	"template/internal/response"
+	"template/internal/user"
+	"template/internal/validator"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service   Service
+	validator *validator.Validator
+}
+
+func NewHandler(service Service, validator *validator.Validator) *Handler {
+	return &Handler{
+		service:   service,
+		validator: validator,
+	}
+}
+
+func (h *Handler) RegisterRoutes(g *echo.Group) {
+	g.POST("/auth/device/code", h.Code)
+	g.POST("/auth/device/token", h.Token)
+}
+
+func (h *Handler) RegisterProtectedRoutes(g *echo.Group) {
+	g.POST("/auth/device/approve", h.Approve)
+}
+
+// Code godoc
+// @Summary Start device authorization
+// @Description Issue a device code and a user code for a client that cannot open a browser (RFC 8628). Responses are not wrapped in the usual envelope.
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce json
+// @Param request body CodeRequest false "Device Code Request"
+// @Success 200 {object} device.CodeResponse
+// @Failure 400 {object} device.TokenError
+// @Failure 500 {object} device.TokenError
+// @Router /auth/device/code [post]
+func (h *Handler) Code(c echo.Context) error {
+	var req CodeRequest
+	if err := c.Bind(&req); err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "Malformed request")
+	}
+
+	code, err := h.service.RequestCode(c.Request().Context(), req.ClientID)
+	if err != nil {
+		return tokenError(c, http.StatusInternalServerError, "server_error", "Something went wrong")
+	}
+
+	return c.JSON(http.StatusOK, code)
+}
+
+// Token godoc
+// @Summary Poll for device tokens
+// @Description Exchange an approved device code for tokens. Returns authorization_pending, slow_down, access_denied or expired_token until then. Responses are not wrapped in the usual envelope (RFC 6749 section 5).
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce json
+// @Param request body TokenRequest true "Device Token Request"
+// @Success 200 {object} jwt.TokenPair
+// @Failure 400 {object} device.TokenError
+// @Failure 500 {object} device.TokenError
+// @Router /auth/device/token [post]
+func (h *Handler) Token(c echo.Context) error {
+	var req TokenRequest
+	if err := c.Bind(&req); err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "Malformed request")
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		if req.GrantType != "" && req.GrantType != GrantType {
+			return tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be "+GrantType)
+		}
+		return tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	client := user.ClientInfo{
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+
+	tokens, err := h.service.PollToken(c.Request().Context(), req.DeviceCode, client)
+	if err != nil {
+		switch err {
+		case ErrAuthorizationPending:
+			return tokenError(c, http.StatusBadRequest, err.Error(), "The user has not approved the request yet")
+		case ErrSlowDown:
+			return tokenError(c, http.StatusBadRequest, err.Error(), "Polling too fast, increase the interval by 5 seconds")
+		case ErrAccessDenied:
+			return tokenError(c, http.StatusBadRequest, err.Error(), "The user denied the request")
+		case ErrExpiredToken:
+			return tokenError(c, http.StatusBadRequest, err.Error(), "The device code has expired")
+		}
+		return tokenError(c, http.StatusInternalServerError, "server_error", "Something went wrong")
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// tokenError writes an OAuth error response.
+func tokenError(c echo.Context, status int, code, description string) error {
+	return c.JSON(status, TokenError{Error: code, ErrorDescription: description})
+}
+
+// Approve godoc
+// @Summary Approve a device
+// @Description Approve or deny a pending device authorization by its user code
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body ApproveRequest true "Device Approve Request"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /auth/device/approve [post]
+func (h *Handler) Approve(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var req ApproveRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	err := h.service.Approve(c.Request().Context(), claims.UserID, req.UserCode, req.Deny)
+	if err != nil {
+		if err == ErrInvalidUserCode {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_USER_CODE", "Invalid or expired user code", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	message := "Device approved"
+	if req.Deny {
+		message = "Device denied"
+	}
+	return response.JSON(c, http.StatusOK, map[string]string{"message": message}, nil)
+}
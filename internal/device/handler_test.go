@@ -0,0 +1,64 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"template/internal/validator"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestTokenUsesOAuthResponses(t *testing.T) {
+	s, _, _ := newTestService(t)
+	e := echo.New()
+	NewHandler(s, validator.New()).RegisterRoutes(e.Group(""))
+
+	code, err := s.RequestCode(context.Background(), "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poll := func() (int, map[string]any) {
+		form := url.Values{"grant_type": {GrantType}, "device_code": {code.DeviceCode}}
+		req := httptest.NewRequest(http.MethodPost, "/auth/device/token", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		var body map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, body
+	}
+
+	status, body := poll()
+	if status != http.StatusBadRequest || body["error"] != "authorization_pending" {
+		t.Errorf("pending poll = %d %v; want 400 with error authorization_pending", status, body)
+	}
+
+	// Approve, and wait out the interval by moving the last poll back
+	if err = s.Approve(context.Background(), "user-1", code.UserCode, false); err != nil {
+		t.Fatal(err)
+	}
+	auth, raw, err := s.load(context.Background(), code.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.LastPolled = auth.LastPolled.Add(-time.Minute)
+	if _, err = s.swap(context.Background(), code.DeviceCode, raw, auth); err != nil {
+		t.Fatal(err)
+	}
+
+	status, body = poll()
+	if status != http.StatusOK || body["access_token"] != "access-user-1" {
+		t.Errorf("approved poll = %d %v; want 200 with a top-level access_token", status, body)
+	}
+}
@@ -0,0 +1,279 @@
+package device
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"template/internal/jwt"
+	"template/internal/redis"
+	"template/internal/user"
+)
+
+// userCodeAlphabet avoids vowels and look-alike characters (RFC 8628 section 6.1).
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+type Service interface {
+	RequestCode(ctx context.Context, clientID string) (*CodeResponse, error)
+	PollToken(ctx context.Context, deviceCode string, client user.ClientInfo) (*jwt.TokenPair, error)
+	Approve(ctx context.Context, userID, userCode string, deny bool) error
+}
+
+type service struct {
+	redis        *redis.Client
+	userService  user.Service
+	frontendHost string
+}
+
+func NewService(redisClient *redis.Client, userService user.Service, frontendHost string) Service {
+	return &service{
+		redis:        redisClient,
+		userService:  userService,
+		frontendHost: frontendHost,
+	}
+}
+
+func (s *service) RequestCode(ctx context.Context, clientID string) (*CodeResponse, error) {
+	deviceCode, err := randomDeviceCode()
+	if err != nil {
+		return nil, err
+	}
+
+	userCode, err := s.claimUserCode(ctx, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Authorization{
+		ClientID: clientID,
+		UserCode: userCode,
+		Status:   StatusPending,
+		Interval: int(pollInterval.Seconds()),
+	}
+
+	raw, err := json.Marshal(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.redis.Set(ctx, deviceCodeKey(deviceCode), raw, codeTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationURI := fmt.Sprintf("%s/device", s.frontendHost)
+	return &CodeResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: fmt.Sprintf("%s?user_code=%s", verificationURI, userCode),
+		ExpiresIn:               int(codeTTL.Seconds()),
+		Interval:                auth.Interval,
+	}, nil
+}
+
+// claimUserCode maps a fresh user code to deviceCode. Codes are short, so it
+// draws another one when the code is held by a different device; approving it
+// would otherwise hand that device's session to this one.
+func (s *service) claimUserCode(ctx context.Context, deviceCode string) (string, error) {
+	for attempt := 0; attempt < userCodeAttempts; attempt++ {
+		userCode, err := randomUserCode()
+		if err != nil {
+			return "", err
+		}
+
+		claimed, err := s.redis.SetNX(ctx, userCodeKey(userCode), deviceCode, codeTTL)
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			return userCode, nil
+		}
+	}
+
+	return "", errNoFreeUserCode
+}
+
+// PollToken reports the state of a device code and issues tokens once it is
+// approved. Every change is a check-and-set against the state that was read,
+// so a poll racing with another poll or with Approve never overwrites it; the
+// loser is told to slow down and sees the new state on its next poll.
+func (s *service) PollToken(ctx context.Context, deviceCode string, client user.ClientInfo) (*jwt.TokenPair, error) {
+	auth, raw, err := s.load(ctx, deviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients polling faster than the interval have to back off further
+	if time.Since(auth.LastPolled) < time.Duration(auth.Interval)*time.Second {
+		auth.Interval += int(slowDownPenalty.Seconds())
+		auth.LastPolled = time.Now()
+		_, err = s.swap(ctx, deviceCode, raw, auth)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrSlowDown
+	}
+
+	switch auth.Status {
+	case StatusApproved:
+		// Consume the code so concurrent polls cannot both get tokens
+		deleted, err := s.redis.CompareAndDelete(ctx, deviceCodeKey(deviceCode), raw)
+		if err != nil {
+			return nil, err
+		}
+		if !deleted {
+			return nil, ErrSlowDown
+		}
+		_ = s.redis.Del(ctx, userCodeKey(auth.UserCode))
+
+		tokens, err := s.userService.CreateSession(ctx, auth.UserID, user.LoginMethodDevice, client)
+		switch err {
+		case user.ErrUserNotFound, user.ErrAccountSuspended, user.ErrAccountPendingDeletion, user.ErrPasswordExpired:
+			// The account can no longer sign in; the code is spent either way
+			return nil, ErrAccessDenied
+		}
+		return tokens, err
+	case StatusDenied:
+		_, err = s.redis.CompareAndDelete(ctx, deviceCodeKey(deviceCode), raw)
+		if err != nil {
+			return nil, err
+		}
+		_ = s.redis.Del(ctx, userCodeKey(auth.UserCode))
+		return nil, ErrAccessDenied
+	default:
+		auth.LastPolled = time.Now()
+		swapped, err := s.swap(ctx, deviceCode, raw, auth)
+		if err != nil {
+			return nil, err
+		}
+		if !swapped {
+			return nil, ErrSlowDown
+		}
+		return nil, ErrAuthorizationPending
+	}
+}
+
+// Approve approves or denies a pending device code. Polls may update the state
+// between reading and writing it, so the check-and-set is retried on the fresh
+// state.
+func (s *service) Approve(ctx context.Context, userID, userCode string, deny bool) error {
+	userCode = normalizeUserCode(userCode)
+
+	deviceCode, err := s.redis.Get(ctx, userCodeKey(userCode))
+	if errors.Is(err, redis.Nil) {
+		return ErrInvalidUserCode
+	}
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < approveAttempts; attempt++ {
+		auth, raw, err := s.load(ctx, deviceCode)
+		if err != nil {
+			if err == ErrExpiredToken {
+				return ErrInvalidUserCode
+			}
+			return err
+		}
+		if auth.Status != StatusPending {
+			return ErrInvalidUserCode
+		}
+
+		auth.Status = StatusApproved
+		auth.UserID = userID
+		if deny {
+			auth.Status = StatusDenied
+			auth.UserID = ""
+		}
+
+		swapped, err := s.swap(ctx, deviceCode, raw, auth)
+		if err != nil || swapped {
+			return err
+		}
+	}
+
+	return ErrInvalidUserCode
+}
+
+// load returns the state of a device code along with its raw value, which
+// swap takes to detect concurrent changes.
+func (s *service) load(ctx context.Context, deviceCode string) (*Authorization, string, error) {
+	raw, err := s.redis.Get(ctx, deviceCodeKey(deviceCode))
+	if errors.Is(err, redis.Nil) {
+		return nil, "", ErrExpiredToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var auth Authorization
+	err = json.Unmarshal([]byte(raw), &auth)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &auth, raw, nil
+}
+
+// swap stores auth if the device code still holds old, keeping its TTL, and
+// reports whether it did.
+func (s *service) swap(ctx context.Context, deviceCode, old string, auth *Authorization) (bool, error) {
+	raw, err := json.Marshal(auth)
+	if err != nil {
+		return false, err
+	}
+
+	return s.redis.CompareAndSwap(ctx, deviceCodeKey(deviceCode), old, raw)
+}
+
+func deviceCodeKey(deviceCode string) string {
+	return "device_code:" + deviceCode
+}
+
+func userCodeKey(userCode string) string {
+	return "device_user_code:" + userCode
+}
+
+func randomDeviceCode() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomUserCode returns a code like "WDJB-MJHT" that is easy to type. Each
+// character is drawn uniformly from userCodeAlphabet.
+func randomUserCode() (string, error) {
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+
+	code := make([]byte, 0, 9)
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code = append(code, userCodeAlphabet[n.Int64()])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode accepts codes typed in lower case or without the dash.
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
@@ -0,0 +1,188 @@
+package device
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"template/internal/jwt"
+	"template/internal/redis"
+	"template/internal/user"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// fakeUserService counts the sessions created for approved device codes,
+// failing with err when it is set.
+type fakeUserService struct {
+	user.Service
+	sessions atomic.Int32
+	err      error
+}
+
+func (f *fakeUserService) CreateSession(ctx context.Context, userID, method string, client user.ClientInfo) (*jwt.TokenPair, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.sessions.Add(1)
+	return &jwt.TokenPair{AccessToken: "access-" + userID}, nil
+}
+
+func newTestService(t *testing.T) (*service, *fakeUserService, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	users := &fakeUserService{}
+	client := &redis.Client{Client: goredis.NewClient(&goredis.Options{Addr: mr.Addr()})}
+	t.Cleanup(func() { _ = client.Client.Close() })
+	return NewService(client, users, "https://app.example.com").(*service), users, mr
+}
+
+func TestRandomUserCodeIsUniform(t *testing.T) {
+	const codes = 20000
+	counts := make(map[rune]int)
+	for i := 0; i < codes; i++ {
+		code, err := randomUserCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 9 || code[4] != '-' {
+			t.Fatalf("code %q is not formatted as XXXX-XXXX", code)
+		}
+		for _, r := range strings.Replace(code, "-", "", 1) {
+			counts[r]++
+		}
+	}
+
+	// A modulo bias would give the first 16 characters 13/256 of the draws
+	// and the last 4 only 12/256, about 6% off the expected count
+	expected := float64(codes*8) / float64(len(userCodeAlphabet))
+	for _, r := range userCodeAlphabet {
+		if got := float64(counts[r]); got < expected*0.95 || got > expected*1.05 {
+			t.Errorf("%c drawn %v times; want about %v", r, got, expected)
+		}
+	}
+	if len(counts) != len(userCodeAlphabet) {
+		t.Errorf("drew %d distinct characters; want %d", len(counts), len(userCodeAlphabet))
+	}
+}
+
+func TestPollTokenIssuesTokensOnce(t *testing.T) {
+	s, users, _ := newTestService(t)
+	ctx := context.Background()
+
+	code, err := s.RequestCode(ctx, "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Approve(ctx, "user-1", strings.ToLower(code.UserCode), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var issued atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens, pollErr := s.PollToken(ctx, code.DeviceCode, user.ClientInfo{})
+			if pollErr == nil && tokens != nil {
+				issued.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if issued.Load() != 1 || users.sessions.Load() != 1 {
+		t.Fatalf("issued %d token pairs and %d sessions; want 1", issued.Load(), users.sessions.Load())
+	}
+
+	_, err = s.PollToken(ctx, code.DeviceCode, user.ClientInfo{})
+	if err != ErrExpiredToken {
+		t.Errorf("poll after issuing error = %v; want %v", err, ErrExpiredToken)
+	}
+}
+
+func TestStalePollDoesNotOverwriteApproval(t *testing.T) {
+	s, users, mr := newTestService(t)
+	ctx := context.Background()
+
+	code, err := s.RequestCode(ctx, "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A poll reads the pending state, then the user approves before it writes
+	auth, raw, err := s.load(ctx, code.DeviceCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Approve(ctx, "user-1", code.UserCode, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swapped, err := s.swap(ctx, code.DeviceCode, raw, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swapped {
+		t.Fatal("stale poll overwrote the approved state")
+	}
+	if ttl := mr.TTL(deviceCodeKey(code.DeviceCode)); ttl <= 0 {
+		t.Errorf("device code TTL = %v; want it kept", ttl)
+	}
+
+	tokens, err := s.PollToken(ctx, code.DeviceCode, user.ClientInfo{})
+	if err != nil || tokens == nil || users.sessions.Load() != 1 {
+		t.Fatalf("PollToken() = %v, %v; want tokens", tokens, err)
+	}
+}
+
+func TestApproveOnlyOnce(t *testing.T) {
+	s, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	code, err := s.RequestCode(ctx, "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Approve(ctx, "user-1", code.UserCode, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Approve(ctx, "user-2", code.UserCode, false)
+	if err != ErrInvalidUserCode {
+		t.Fatalf("second Approve() error = %v; want %v", err, ErrInvalidUserCode)
+	}
+
+	_, err = s.PollToken(ctx, code.DeviceCode, user.ClientInfo{})
+	if err != ErrAccessDenied {
+		t.Errorf("PollToken() error = %v; want %v", err, ErrAccessDenied)
+	}
+}
+
+func TestPollTokenDeniesAccountsThatCannotSignIn(t *testing.T) {
+	for _, err := range []error{user.ErrAccountSuspended, user.ErrAccountPendingDeletion, user.ErrPasswordExpired} {
+		s, users, _ := newTestService(t)
+		users.err = err
+		ctx := context.Background()
+
+		code, reqErr := s.RequestCode(ctx, "cli")
+		if reqErr != nil {
+			t.Fatal(reqErr)
+		}
+		if approveErr := s.Approve(ctx, "user-1", code.UserCode, false); approveErr != nil {
+			t.Fatal(approveErr)
+		}
+
+		_, pollErr := s.PollToken(ctx, code.DeviceCode, user.ClientInfo{})
+		if pollErr != ErrAccessDenied {
+			t.Errorf("PollToken() with %v error = %v; want %v", err, pollErr, ErrAccessDenied)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package dpop verifies RFC 9449 DPoP proofs, which bind tokens to a key held by
+// the client so a stolen token cannot be replayed without that key.
+package dpop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"template/internal/redis"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HeaderName is the request header carrying the proof.
+const HeaderName = "DPoP"
+
+var (
+	ErrInvalidProof  = errors.New("invalid dpop proof")
+	ErrReplayedProof = errors.New("dpop proof already used")
+)
+
+type proofClaims struct {
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verifier checks DPoP proofs and remembers their jti in Redis to reject replays.
+type Verifier struct {
+	redis  *redis.Client
+	maxAge time.Duration
+}
+
+func NewVerifier(redisClient *redis.Client, maxAge time.Duration) *Verifier {
+	return &Verifier{
+		redis:  redisClient,
+		maxAge: maxAge,
+	}
+}
+
+// Verify validates a proof for the given HTTP method and URL (without query or
+// fragment) and returns the thumbprint of the key it was signed with. When
+// accessToken is not empty the proof must carry its hash in "ath".
+func (v *Verifier) Verify(ctx context.Context, proof, method, url, accessToken string) (string, error) {
+	var jkt string
+	claims := &proofClaims{}
+
+	token, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, ErrInvalidProof
+		}
+
+		raw, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+
+		var key JWK
+		err = json.Unmarshal(raw, &key)
+		if err != nil {
+			return nil, err
+		}
+
+		jkt, err = key.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+
+		return key.PublicKey()
+	}, jwt.WithValidMethods([]string{"ES256", "ES384", "ES512", "RS256", "PS256", "EdDSA"}))
+	if err != nil || !token.Valid {
+		return "", ErrInvalidProof
+	}
+
+	if claims.HTM != method || claims.HTU != url || claims.ID == "" || claims.IssuedAt == nil {
+		return "", ErrInvalidProof
+	}
+
+	age := time.Since(claims.IssuedAt.Time)
+	if age > v.maxAge || age < -v.maxAge {
+		return "", ErrInvalidProof
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", ErrInvalidProof
+		}
+	}
+
+	// A jti only needs to be remembered for as long as its proof is acceptable
+	fresh, err := v.redis.SetNX(ctx, "dpop_jti:"+jkt+":"+claims.ID, 1, 2*v.maxAge)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", ErrReplayedProof
+	}
+
+	return jkt, nil
+}
+
+// RequestURL returns the "htu" value a proof for r must carry: the request URL
+// without query and fragment. scheme is passed in because it may come from a
+// proxy header rather than the connection.
+func RequestURL(r *http.Request, scheme string) string {
+	return scheme + "://" + r.Host + r.URL.Path
+}
@@ -5,6 +5,11 @@ import (
 	"net/smtp"
 )
 
+// Mailer sends HTML emails. Sender is the SMTP implementation.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
 type Sender struct {
 	Host     string
 	Port     int
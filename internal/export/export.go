@@ -0,0 +1,63 @@
+// Package export assembles a user's personal data into a downloadable ZIP of
+// JSON files, one per section. Modules contribute sections through a Registry
+// so the export stays complete as new data is stored.
+package export
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrExportInProgress = errors.New("an export is already in progress")
+	ErrExportNotFound   = errors.New("export not found")
+	ErrExportNotReady   = errors.New("export not ready")
+)
+
+// Job statuses.
+const (
+	StatusPending   = "pending"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// buildTimeout bounds how long assembling one export may take.
+const buildTimeout = 5 * time.Minute
+
+// finishTimeout bounds recording the outcome of a build, which gets its own
+// context as the build's may have run out.
+const finishTimeout = 10 * time.Second
+
+// SectionFunc returns the data of one section for a user. The result is encoded
+// as JSON.
+type SectionFunc func(ctx context.Context, userID string) (interface{}, error)
+
+type section struct {
+	name   string
+	export SectionFunc
+}
+
+// Registry holds the sections included in every export, in registration order.
+type Registry struct {
+	sections []section
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a section written to <name>.json in the archive.
+func (r *Registry) Register(name string, fn SectionFunc) {
+	r.sections = append(r.sections, section{name: name, export: fn})
+}
+
+// Job is the state of one export request, kept in Redis.
+type Job struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"-"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+}
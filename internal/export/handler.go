@@ -0,0 +1,122 @@
+package export
+
+import (
+	"fmt"
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/jwt"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+func (h *Handler) RegisterRoutes(g *echo.Group) {
+	g.POST("/users/me/export", h.Request)
+	g.GET("/users/me/export/:id", h.Status)
+	g.GET("/users/me/export/:id/download", h.Download)
+}
+
+// Request godoc
+// @Summary Request a personal data export
+// @Description Start assembling everything held about the current user into a ZIP of JSON files. Poll the returned job until it is completed, then download it.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 202 {object} response.Response{data=export.Job}
+// @Failure 401 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/export [post]
+func (h *Handler) Request(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	job, err := h.service.Request(c.Request().Context(), claims.UserID)
+	if err != nil {
+		if err == ErrExportInProgress {
+			return response.ErrorJSON(c, http.StatusConflict, "EXPORT_IN_PROGRESS", "An export is already being prepared", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusAccepted, job, nil)
+}
+
+// Status godoc
+// @Summary Get export status
+// @Description Get the status of a personal data export
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Export ID"
+// @Success 200 {object} response.Response{data=export.Job}
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/export/{id} [get]
+func (h *Handler) Status(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	job, err := h.service.Get(c.Request().Context(), claims.UserID, c.Param("id"))
+	if err != nil {
+		if err == ErrExportNotFound {
+			return json.NotFound(c, "Export not found")
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, job, nil)
+}
+
+// Download godoc
+// @Summary Download an export
+// @Description Download a completed personal data export as a ZIP archive
+// @Tags users
+// @Produce application/zip
+// @Security ApiKeyAuth
+// @Param id path string true "Export ID"
+// @Success 200 {file} file
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/export/{id}/download [get]
+func (h *Handler) Download(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	id := c.Param("id")
+	archive, err := h.service.Download(c.Request().Context(), claims.UserID, id)
+	if err != nil {
+		if err == ErrExportNotFound {
+			return json.NotFound(c, "Export not found")
+		}
+		if err == ErrExportNotReady {
+			return response.ErrorJSON(c, http.StatusConflict, "EXPORT_NOT_READY", "The export is not ready for download", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", "export-"+id+".zip"))
+	return c.Blob(http.StatusOK, "application/zip", archive)
+}
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"template/internal/redis"
+
+	"github.com/google/uuid"
+)
+
+type Service interface {
+	Request(ctx context.Context, userID string) (*Job, error)
+	Get(ctx context.Context, userID, id string) (*Job, error)
+	Download(ctx context.Context, userID, id string) ([]byte, error)
+}
+
+type service struct {
+	registry *Registry
+	redis    *redis.Client
+	ttl      time.Duration
+}
+
+// NewService returns a Service that keeps jobs and finished archives in Redis
+// for ttl.
+func NewService(registry *Registry, redisClient *redis.Client, ttl time.Duration) Service {
+	return &service{
+		registry: registry,
+		redis:    redisClient,
+		ttl:      ttl,
+	}
+}
+
+// Request starts assembling an export in the background. Only one export per
+// user can be in progress at a time.
+func (s *service) Request(ctx context.Context, userID string) (*Job, error) {
+	now := time.Now()
+	job := &Job{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Status:    StatusPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+
+	ok, err := s.redis.SetNX(ctx, activeKey(userID), job.ID, buildTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrExportInProgress
+	}
+
+	err = s.save(ctx, job)
+	if err != nil {
+		_ = s.redis.Del(ctx, activeKey(userID))
+		return nil, err
+	}
+
+	go s.build(job)
+
+	return job, nil
+}
+
+func (s *service) Get(ctx context.Context, userID, id string) (*Job, error) {
+	data, err := s.redis.Get(ctx, jobKey(userID, id))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, err
+	}
+	job.UserID = userID
+
+	return &job, nil
+}
+
+func (s *service) Download(ctx context.Context, userID, id string) ([]byte, error) {
+	job, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != StatusCompleted {
+		return nil, ErrExportNotReady
+	}
+
+	data, err := s.redis.Get(ctx, archiveKey(userID, id))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+
+	return []byte(data), nil
+}
+
+// build runs detached from the request that started it.
+func (s *service) build(job *Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), buildTimeout)
+	defer cancel()
+
+	archive, err := s.assemble(ctx, job.UserID)
+	if err == nil {
+		err = s.redis.Set(ctx, archiveKey(job.UserID, job.ID), archive, s.ttl)
+	}
+
+	now := time.Now()
+	job.CompletedAt = &now
+	job.Status = StatusCompleted
+	if err != nil {
+		log.Printf("export %s failed: %v", job.ID, err)
+		job.Status = StatusFailed
+	}
+
+	// A timed out build must still be marked failed and let the user retry
+	finishCtx, cancelFinish := context.WithTimeout(context.Background(), finishTimeout)
+	defer cancelFinish()
+	_ = s.save(finishCtx, job)
+	_ = s.redis.Del(finishCtx, activeKey(job.UserID))
+}
+
+// assemble writes every registered section to its own JSON file in a ZIP.
+func (s *service) assemble(ctx context.Context, userID string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	for _, sec := range s.registry.sections {
+		data, err := sec.export(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", sec.name, err)
+		}
+
+		encoded, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("section %s: %w", sec.name, err)
+		}
+
+		w, err := zw.Create(sec.name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(encoded); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *service) save(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Set(ctx, jobKey(job.UserID, job.ID), data, time.Until(job.ExpiresAt))
+}
+
+// Keys are scoped by user so one user can never read another's export.
+func jobKey(userID, id string) string {
+	return fmt.Sprintf("export:%s:%s", userID, id)
+}
+
+func archiveKey(userID, id string) string {
+	return fmt.Sprintf("export:%s:%s:archive", userID, id)
+}
+
+func activeKey(userID string) string {
+	return fmt.Sprintf("export:%s:active", userID)
+}
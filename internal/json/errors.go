@@ -23,3 +23,7 @@ func Unauthorized(c echo.Context, message string) error {
 func NotFound(c echo.Context, message string) error {
 	return response.ErrorJSON(c, http.StatusNotFound, "NOT_FOUND", message, nil)
 }
+
+func Forbidden(c echo.Context, message string) error {
+	return response.ErrorJSON(c, http.StatusForbidden, "FORBIDDEN", message, nil)
+}
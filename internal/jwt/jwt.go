@@ -14,25 +14,77 @@ var (
 	ErrExpiredToken = errors.New("expired token")
 )
 
+// Subjects distinguish single-purpose tokens from access tokens.
+const (
+	SubjectPasswordReset  = "password_reset"
+	SubjectEmailChange    = "email_change"
+	SubjectSessionReport  = "session_report"
+	SubjectCancelDeletion = "cancel_deletion"
+	SubjectImportInvite   = "import_invite"
+)
+
+// Token types reported in TokenPair.
+const (
+	TokenTypeBearer = "Bearer"
+	TokenTypeDPoP   = "DPoP"
+)
+
 type Claims struct {
-	UserID string `json:"user_id"`
+	UserID        string           `json:"user_id"`
+	Role          string           `json:"role,omitempty"`
+	SessionID     string           `json:"sid,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	PreviousEmail string           `json:"prev_email,omitempty"`
+	DeletionAt    *jwt.NumericDate `json:"deletion_at,omitempty"`
+	Scope         string           `json:"scope,omitempty"`
+	Confirmation  *Confirmation    `json:"cnf,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Confirmation binds a token to a DPoP key by its JWK thumbprint (RFC 9449).
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// TokenOptions carries the session-specific values embedded in an access token.
+type TokenOptions struct {
+	Role      string
+	SessionID string
+	// Scope is the space-separated list of scopes granted to the token.
+	Scope string
+	// JKT binds the access token to a DPoP key when set.
+	JKT string
+	// TTL is the access token lifetime.
+	TTL time.Duration
+}
+
 type TokenPair struct {
 	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	// ExpiresIn is the access token lifetime in seconds.
+	ExpiresIn int `json:"expires_in"`
+	// RefreshExpiresIn is the refresh token lifetime in seconds.
+	RefreshExpiresIn int `json:"refresh_expires_in,omitempty"`
 }
 
-func GenerateTokens(userID, secret string) (*TokenPair, error) {
+func GenerateTokens(userID, secret string, opts TokenOptions) (*TokenPair, error) {
 	// Access Token
 	claims := Claims{
-		UserID: userID,
+		UserID:    userID,
+		Role:      opts.Role,
+		SessionID: opts.SessionID,
+		Scope:     opts.Scope,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(opts.TTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
+	tokenType := TokenTypeBearer
+	if opts.JKT != "" {
+		claims.Confirmation = &Confirmation{JKT: opts.JKT}
+		tokenType = TokenTypeDPoP
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	accessToken, err := token.SignedString([]byte(secret))
 	if err != nil {
@@ -52,15 +104,78 @@ func GenerateTokens(userID, secret string) (*TokenPair, error) {
 	return &TokenPair{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		TokenType:    tokenType,
+		ExpiresIn:    int(opts.TTL.Seconds()),
 	}, nil
 }
 
-func GenerateResetToken(userID, secret string) (string, error) {
+func GenerateResetToken(userID, secret string, ttl time.Duration) (string, error) {
 	claims := Claims{
 		UserID: userID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)),
-			Subject:   "password_reset",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			Subject:   SubjectPasswordReset,
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// GenerateEmailChangeToken creates the token behind the link that moves an
+// account from currentEmail to newEmail. It is only valid while the account
+// still has currentEmail.
+func GenerateEmailChangeToken(userID, currentEmail, newEmail, secret string, ttl time.Duration) (string, error) {
+	claims := Claims{
+		UserID:        userID,
+		Email:         newEmail,
+		PreviousEmail: currentEmail,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			Subject:   SubjectEmailChange,
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// GenerateSessionReportToken creates the token behind a "this wasn't me" link
+// for a newly signed-in session.
+func GenerateSessionReportToken(userID, sessionID, secret string, ttl time.Duration) (string, error) {
+	claims := Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			Subject:   SubjectSessionReport,
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// GenerateCancelDeletionToken creates the token behind the link that cancels
+// the account deletion scheduled for deletionAt.
+func GenerateCancelDeletionToken(userID string, deletionAt time.Time, secret string, ttl time.Duration) (string, error) {
+	claims := Claims{
+		UserID:     userID,
+		DeletionAt: jwt.NewNumericDate(deletionAt),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			Subject:   SubjectCancelDeletion,
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(secret))
+}
+
+// GenerateImportInviteToken creates the token behind the link that lets a user
+// created by a bulk import set their first password.
+func GenerateImportInviteToken(userID, secret string, ttl time.Duration) (string, error) {
+	claims := Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			Subject:   SubjectImportInvite,
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -83,6 +198,20 @@ func ValidateToken(tokenString, secret string) (*Claims, error) {
 	return nil, ErrInvalidToken
 }
 
+// ValidateAccessToken is ValidateToken restricted to access tokens. The
+// single-purpose tokens sent in emails are signed with the same secret and
+// must not be accepted in their place.
+func ValidateAccessToken(tokenString, secret string) (*Claims, error) {
+	claims, err := ValidateToken(tokenString, secret)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject != "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
 func generateRandomString(n int) (string, error) {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
@@ -3,13 +3,14 @@ package middleware
 import (
 	"strings"
 
+	"template/internal/dpop"
 	"template/internal/json"
 	"template/internal/jwt"
 
 	"github.com/labstack/echo/v4"
 )
 
-func Auth(secret string) echo.MiddlewareFunc {
+func Auth(secret string, dpopVerifier *dpop.Verifier) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			authHeader := c.Request().Header.Get("Authorization")
@@ -18,16 +19,31 @@ func Auth(secret string) echo.MiddlewareFunc {
 			}
 
 			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
+			if len(parts) != 2 || (parts[0] != jwt.TokenTypeBearer && parts[0] != jwt.TokenTypeDPoP) {
 				return json.Unauthorized(c, "Invalid authorization header format")
 			}
 
 			tokenString := parts[1]
-			claims, err := jwt.ValidateToken(tokenString, secret)
+			claims, err := jwt.ValidateAccessToken(tokenString, secret)
 			if err != nil {
 				return json.Unauthorized(c, "Invalid or expired token")
 			}
 
+			// Bound tokens must come with a proof from the same key, never as Bearer
+			if claims.Confirmation != nil {
+				if parts[0] != jwt.TokenTypeDPoP {
+					return json.Unauthorized(c, "DPoP-bound token requires the DPoP scheme")
+				}
+
+				req := c.Request()
+				jkt, proofErr := dpopVerifier.Verify(req.Context(), req.Header.Get(dpop.HeaderName), req.Method, dpop.RequestURL(req, c.Scheme()), tokenString)
+				if proofErr != nil || jkt != claims.Confirmation.JKT {
+					return json.Unauthorized(c, "Invalid DPoP proof")
+				}
+			} else if parts[0] != jwt.TokenTypeBearer {
+				return json.Unauthorized(c, "Invalid authorization header format")
+			}
+
 			c.Set("user", claims)
 			return next(c)
 		}
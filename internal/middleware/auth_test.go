@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"template/internal/jwt"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testSecret = "secret"
+
+func TestAuthRejectsSinglePurposeTokens(t *testing.T) {
+	access, err := jwt.GenerateTokens("user-1", testSecret, jwt.TokenOptions{Role: "user", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reset, err := jwt.GenerateResetToken("user-1", testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := jwt.GenerateSessionReportToken("user-1", "session-1", testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emailChange, err := jwt.GenerateEmailChangeToken("user-1", "old@example.com", "new@example.com", testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelDeletion, err := jwt.GenerateCancelDeletionToken("user-1", time.Now().Add(time.Hour), testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"access token", access.AccessToken, http.StatusOK},
+		{"password reset token", reset, http.StatusUnauthorized},
+		{"session report token", report, http.StatusUnauthorized},
+		{"email change token", emailChange, http.StatusUnauthorized},
+		{"cancel deletion token", cancelDeletion, http.StatusUnauthorized},
+	}
+
+	e := echo.New()
+	e.GET("/protected", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, Auth(testSecret, nil))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", jwt.TokenTypeBearer+" "+tt.token)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d; want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"template/internal/json"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ClientCredentials authenticates confidential clients (such as an API gateway)
+// with HTTP Basic auth against the configured client ID to secret map. The client
+// ID is stored in the context under "client_id".
+func ClientCredentials(clients map[string]string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id, secret, ok := c.Request().BasicAuth()
+			if !ok {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="api"`)
+				return json.Unauthorized(c, "Missing client credentials")
+			}
+
+			expected, known := clients[id]
+			// Compare even for unknown clients to keep timing uniform
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 || !known || expected == "" {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="api"`)
+				return json.Unauthorized(c, "Invalid client credentials")
+			}
+
+			c.Set("client_id", id)
+			return next(c)
+		}
+	}
+}
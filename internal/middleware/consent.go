@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/jwt"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ConsentChecker reports whether a user still has to accept a mandatory legal
+// document.
+type ConsentChecker interface {
+	HasPendingConsents(ctx context.Context, userID string) (bool, error)
+}
+
+// RequireConsent rejects requests from users who have not accepted the latest
+// mandatory terms with a CONSENT_REQUIRED error. It must run after Auth, and the
+// routes used to review and accept documents must not be behind it.
+func RequireConsent(checker ConsentChecker) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get("user").(*jwt.Claims)
+			if !ok {
+				return json.Unauthorized(c, "Invalid token")
+			}
+
+			pending, err := checker.HasPendingConsents(c.Request().Context(), claims.UserID)
+			if err != nil {
+				return json.InternalServerError(c, err)
+			}
+			if pending {
+				return response.ErrorJSON(c, http.StatusForbidden, "CONSENT_REQUIRED", "You must accept the updated terms to continue", nil)
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -12,10 +12,20 @@ import (
 )
 
 func RateLimit(redisClient *redis.Client, limit int, window time.Duration) echo.MiddlewareFunc {
+	return rateLimit(redisClient, "rate_limit", limit, window)
+}
+
+// RateLimitBucket limits requests per IP in a bucket counted separately from the
+// global limit, for routes that need a stricter budget.
+func RateLimitBucket(redisClient *redis.Client, bucket string, limit int, window time.Duration) echo.MiddlewareFunc {
+	return rateLimit(redisClient, "rate_limit:"+bucket, limit, window)
+}
+
+func rateLimit(redisClient *redis.Client, prefix string, limit int, window time.Duration) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			ip := c.RealIP()
-			key := fmt.Sprintf("rate_limit:%s", ip)
+			key := fmt.Sprintf("%s:%s", prefix, ip)
 
 			count, err := redisClient.Client.Incr(c.Request().Context(), key).Result()
 			if err != nil {
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"template/internal/json"
+	"template/internal/jwt"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireRole only lets through users whose token carries one of roles. It must
+// run after Auth.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get("user").(*jwt.Claims)
+			if !ok {
+				return json.Unauthorized(c, "Invalid token")
+			}
+
+			for _, role := range roles {
+				if claims.Role == role {
+					return next(c)
+				}
+			}
+
+			return json.Forbidden(c, "Insufficient permissions")
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package preferences
+
+import (
+	"context"
+
+	"template/internal/export"
+)
+
+// RegisterExportSections adds preferences to personal data exports.
+func RegisterExportSections(registry *export.Registry, service Service) {
+	registry.Register("preferences", func(ctx context.Context, userID string) (interface{}, error) {
+		return service.Get(ctx, userID)
+	})
+}
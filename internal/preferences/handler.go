@@ -0,0 +1,124 @@
+package preferences
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"template/internal/json"
+	"template/internal/jwt"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxPatchBytes bounds preference patches, which are tiny in practice.
+const maxPatchBytes = 64 << 10
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{
+		service: service,
+	}
+}
+
+func (h *Handler) RegisterRoutes(g *echo.Group) {
+	g.GET("/users/me/preferences", h.Get)
+	g.PATCH("/users/me/preferences", h.Update)
+}
+
+// Get godoc
+// @Summary Get preferences
+// @Description Get the current user's preferences with defaults filled in. The ETag header carries the version for conditional updates.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} response.Response{data=preferences.Preferences}
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/preferences [get]
+func (h *Handler) Get(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	doc, err := h.service.GetDocument(c.Request().Context(), claims.UserID)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "private, no-cache")
+	c.Response().Header().Set("ETag", etag(doc.Version))
+	return response.JSON(c, http.StatusOK, doc.Preferences, nil)
+}
+
+// Update godoc
+// @Summary Update preferences
+// @Description Change preferences with JSON merge patch semantics: omitted fields are unchanged and null resets a field to its default. Send the ETag from a previous response in If-Match to fail instead of overwriting concurrent changes.
+// @Tags users
+// @Accept json,application/merge-patch+json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param If-Match header string false "Expected version"
+// @Param request body preferences.Preferences true "Preferences Patch"
+// @Success 200 {object} response.Response{data=preferences.Preferences}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 412 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/preferences [patch]
+func (h *Handler) Update(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var expectedVersion *int
+	if ifMatch := c.Request().Header.Get("If-Match"); ifMatch != "" {
+		version, err := parseETag(ifMatch)
+		if err != nil {
+			return preconditionFailed(c)
+		}
+		expectedVersion = &version
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(c.Request().Body, maxPatchBytes))
+	if err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	doc, err := h.service.Update(c.Request().Context(), claims.UserID, patch, expectedVersion)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPatch) {
+			return json.BadRequest(c, err)
+		}
+		if err == ErrVersionMismatch {
+			return preconditionFailed(c)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	c.Response().Header().Set("ETag", etag(doc.Version))
+	return response.JSON(c, http.StatusOK, doc.Preferences, nil)
+}
+
+func preconditionFailed(c echo.Context) error {
+	return response.ErrorJSON(c, http.StatusPreconditionFailed, "PRECONDITION_FAILED", "Preferences were changed since they were read", nil)
+}
+
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+func parseETag(value string) (int, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+	return strconv.Atoi(strings.Trim(value, `"`))
+}
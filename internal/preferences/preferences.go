@@ -0,0 +1,110 @@
+// Package preferences stores per-user settings as a sparse JSON document of
+// overrides on top of configurable defaults.
+package preferences
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrVersionMismatch = errors.New("preferences version mismatch")
+	ErrInvalidPatch    = errors.New("invalid preferences patch")
+)
+
+// Preferences is the schema of the preferences document. Adding a field only
+// needs a built-in default in builtinDefaults.
+type Preferences struct {
+	Theme         string                  `json:"theme" validate:"oneof=system light dark"`
+	TimeFormat    string                  `json:"time_format" validate:"oneof=12h 24h"`
+	WeekStart     string                  `json:"week_start" validate:"oneof=monday sunday saturday"`
+	Notifications NotificationPreferences `json:"notifications"`
+}
+
+type NotificationPreferences struct {
+	// NewDeviceLogin sends an email when the account is signed into from an
+	// unknown device.
+	NewDeviceLogin bool `json:"new_device_login"`
+	ProductUpdates bool `json:"product_updates"`
+	Newsletter     bool `json:"newsletter"`
+}
+
+// Document is a user's effective preferences along with the version of their
+// stored overrides, which increases with every update. Users who never changed
+// anything are at version 0.
+type Document struct {
+	Version     int
+	Preferences *Preferences
+}
+
+func builtinDefaults() Preferences {
+	return Preferences{
+		Theme:      "system",
+		TimeFormat: "24h",
+		WeekStart:  "monday",
+		Notifications: NotificationPreferences{
+			NewDeviceLogin: true,
+		},
+	}
+}
+
+// resolve applies stored overrides on top of defaults. Overrides are decoded
+// leniently so documents written under an older schema still load.
+func resolve(defaults Preferences, overrides map[string]interface{}) (*Preferences, error) {
+	prefs := defaults
+
+	data, err := json.Marshal(overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &prefs)
+	var typeErr *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &typeErr) {
+		return nil, err
+	}
+
+	return &prefs, nil
+}
+
+// decodeStrict decodes data into v, rejecting unknown fields and trailing data.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON object")
+	}
+	return nil
+}
+
+// mergePatch applies an RFC 7396 JSON merge patch to target: null removes a
+// key, objects are merged recursively and anything else replaces the value.
+// Objects left empty are removed so overrides stay sparse.
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	if target == nil {
+		target = make(map[string]interface{})
+	}
+
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(target, key)
+		case map[string]interface{}:
+			existing, _ := target[key].(map[string]interface{})
+			merged := mergePatch(existing, value)
+			if len(merged) == 0 {
+				delete(target, key)
+			} else {
+				target[key] = merged
+			}
+		default:
+			target[key] = value
+		}
+	}
+
+	return target
+}
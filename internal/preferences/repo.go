@@ -0,0 +1,94 @@
+package preferences
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+type Repository interface {
+	// Get returns a user's stored overrides and their version, which is 0 if
+	// none were ever stored.
+	Get(ctx context.Context, userID string) (map[string]interface{}, int, error)
+	// Save replaces a user's overrides if their version is still expectedVersion
+	// and returns the new version, or ErrVersionMismatch.
+	Save(ctx context.Context, userID string, overrides map[string]interface{}, expectedVersion int) (int, error)
+}
+
+type repository struct {
+	db *sqlx.DB
+	sb squirrel.StatementBuilderType
+}
+
+func NewRepository(db *sqlx.DB) Repository {
+	return &repository{
+		db: db,
+		sb: squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar),
+	}
+}
+
+func (r *repository) Get(ctx context.Context, userID string) (map[string]interface{}, int, error) {
+	var row struct {
+		Document []byte `db:"document"`
+		Version  int    `db:"version"`
+	}
+	query, args, err := r.sb.Select("document", "version").
+		From("user_preferences").
+		Where(squirrel.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.GetContext(ctx, &row, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return map[string]interface{}{}, 0, nil
+		}
+		return nil, 0, err
+	}
+
+	overrides := map[string]interface{}{}
+	err = json.Unmarshal(row.Document, &overrides)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return overrides, row.Version, nil
+}
+
+func (r *repository) Save(ctx context.Context, userID string, overrides map[string]interface{}, expectedVersion int) (int, error) {
+	document, err := json.Marshal(overrides)
+	if err != nil {
+		return 0, err
+	}
+
+	// Inserting only succeeds for version 0; updating only if nobody else
+	// updated in between
+	query, args, err := r.sb.Insert("user_preferences").
+		Columns("user_id", "document").
+		Values(userID, string(document)).
+		Suffix(`ON CONFLICT (user_id) DO UPDATE
+			SET document = EXCLUDED.document, version = user_preferences.version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE user_preferences.version = ?
+			RETURNING version`, expectedVersion).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = r.db.QueryRowContext(ctx, query, args...).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrVersionMismatch
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -0,0 +1,174 @@
+package preferences
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"template/internal/redis"
+	"template/internal/validator"
+)
+
+// maxUpdateAttempts bounds retries of updates that raced with another update
+// and did not ask for a specific version.
+const maxUpdateAttempts = 3
+
+// Reader is the API other modules use to read preferences.
+type Reader interface {
+	Get(ctx context.Context, userID string) (*Preferences, error)
+}
+
+type Service interface {
+	Reader
+	GetDocument(ctx context.Context, userID string) (*Document, error)
+	// Update applies a JSON merge patch. If expectedVersion is not nil the
+	// update fails with ErrVersionMismatch unless it matches.
+	Update(ctx context.Context, userID string, patch []byte, expectedVersion *int) (*Document, error)
+}
+
+type service struct {
+	repo      Repository
+	redis     *redis.Client
+	validator *validator.Validator
+	defaults  Preferences
+	cacheTTL  time.Duration
+}
+
+// NewService merges defaultsJSON, a partial preferences document, over the
+// built-in defaults. Invalid defaults are reported as an error.
+func NewService(repo Repository, redisClient *redis.Client, v *validator.Validator, defaultsJSON string, cacheTTL time.Duration) (Service, error) {
+	defaults := builtinDefaults()
+	if defaultsJSON != "" {
+		if err := decodeStrict([]byte(defaultsJSON), &defaults); err != nil {
+			return nil, fmt.Errorf("invalid preference defaults: %w", err)
+		}
+	}
+	if err := v.Validate(defaults); err != nil {
+		return nil, fmt.Errorf("invalid preference defaults: %w", err)
+	}
+
+	return &service{
+		repo:      repo,
+		redis:     redisClient,
+		validator: v,
+		defaults:  defaults,
+		cacheTTL:  cacheTTL,
+	}, nil
+}
+
+func (s *service) Get(ctx context.Context, userID string) (*Preferences, error) {
+	doc, err := s.GetDocument(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Preferences, nil
+}
+
+func (s *service) GetDocument(ctx context.Context, userID string) (*Document, error) {
+	if doc, ok := s.cached(ctx, userID); ok {
+		return doc, nil
+	}
+
+	overrides, version, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs, err := resolve(s.defaults, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Version: version, Preferences: prefs}
+	s.cache(ctx, userID, doc)
+	return doc, nil
+}
+
+func (s *service) Update(ctx context.Context, userID string, patch []byte, expectedVersion *int) (*Document, error) {
+	// The patch itself must fit the schema; nulls reset fields to their default
+	var patchMap map[string]interface{}
+	err := json.Unmarshal(patch, &patchMap)
+	if err != nil || patchMap == nil {
+		return nil, fmt.Errorf("%w: body must be a JSON object", ErrInvalidPatch)
+	}
+	err = decodeStrict(patch, &Preferences{})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+
+	for range maxUpdateAttempts {
+		var doc *Document
+		doc, err = s.tryUpdate(ctx, userID, patchMap, expectedVersion)
+		if err == ErrVersionMismatch && expectedVersion == nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.invalidate(ctx, userID)
+		return doc, nil
+	}
+
+	return nil, ErrVersionMismatch
+}
+
+func (s *service) tryUpdate(ctx context.Context, userID string, patch map[string]interface{}, expectedVersion *int) (*Document, error) {
+	overrides, version, err := s.repo.Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && *expectedVersion != version {
+		return nil, ErrVersionMismatch
+	}
+
+	overrides = mergePatch(overrides, patch)
+	prefs, err := resolve(s.defaults, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validator.Validate(prefs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+
+	version, err = s.repo.Save(ctx, userID, overrides, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{Version: version, Preferences: prefs}, nil
+}
+
+func (s *service) cached(ctx context.Context, userID string) (*Document, bool) {
+	data, err := s.redis.Get(ctx, cacheKey(userID))
+	if err != nil {
+		return nil, false
+	}
+
+	var doc Document
+	err = json.Unmarshal([]byte(data), &doc)
+	if err != nil || doc.Preferences == nil {
+		return nil, false
+	}
+
+	return &doc, true
+}
+
+func (s *service) cache(ctx context.Context, userID string, doc *Document) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	_ = s.redis.Set(ctx, cacheKey(userID), data, s.cacheTTL)
+}
+
+func (s *service) invalidate(ctx context.Context, userID string) {
+	_ = s.redis.Del(ctx, cacheKey(userID))
+}
+
+func cacheKey(userID string) string {
+	return fmt.Sprintf("preferences:%s", userID)
+}
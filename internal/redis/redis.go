@@ -7,6 +7,30 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Nil is returned by Get when the key does not exist.
+const Nil = redis.Nil
+
+// KeepTTL can be passed as expiration to Set to keep the key's existing TTL.
+const KeepTTL = redis.KeepTTL
+
+// compareAndSwap replaces the value of KEYS[1] with ARGV[2], keeping its TTL,
+// if it still holds ARGV[1].
+var compareAndSwap = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "KEEPTTL")
+	return 1
+end
+return 0
+`)
+
+// compareAndDelete deletes KEYS[1] if it still holds ARGV[1].
+var compareAndDelete = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
 type Client struct {
 	Client *redis.Client
 }
@@ -29,10 +53,34 @@ func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	return c.Client.Get(ctx, key).Result()
 }
 
+// SetNX sets key only if it does not exist and reports whether it was set.
+func (c *Client) SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) (bool, error) {
+	return c.Client.SetNX(ctx, key, value, expiration).Result()
+}
+
 func (c *Client) Del(ctx context.Context, key string) error {
 	return c.Client.Del(ctx, key).Err()
 }
 
+// GetDel gets the value of key and deletes it atomically.
+func (c *Client) GetDel(ctx context.Context, key string) (string, error) {
+	return c.Client.GetDel(ctx, key).Result()
+}
+
+// CompareAndSwap sets key to value, keeping its TTL, only if it still holds
+// old. It reports whether the value was replaced.
+func (c *Client) CompareAndSwap(ctx context.Context, key, old string, value interface{}) (bool, error) {
+	n, err := compareAndSwap.Run(ctx, c.Client, []string{key}, old, value).Int()
+	return n == 1, err
+}
+
+// CompareAndDelete deletes key only if it still holds old. It reports whether
+// the key was deleted.
+func (c *Client) CompareAndDelete(ctx context.Context, key, old string) (bool, error) {
+	n, err := compareAndDelete.Run(ctx, c.Client, []string{key}, old).Int()
+	return n == 1, err
+}
+
 func (c *Client) Health() map[string]string {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
@@ -17,6 +17,20 @@ type Error struct {
 	Details interface{} `json:"details,omitempty"`
 }
 
+// PageMeta is returned as Meta for paginated list endpoints.
+type PageMeta struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+}
+
+// CursorMeta is returned as Meta for cursor-paginated list endpoints.
+// NextCursor is empty on the last page.
+type CursorMeta struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
 func JSON(c echo.Context, status int, data interface{}, meta interface{}) error {
 	return c.JSON(status, Response{
 		Success: true,
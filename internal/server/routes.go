@@ -2,8 +2,11 @@ package server
 
 import (
 	"net/http"
+	"time"
 
+	"template/internal/auth"
 	customMiddleware "template/internal/middleware"
+	"template/internal/user"
 
 	_ "template/docs" // Import docs
 
@@ -18,16 +21,47 @@ func (s *Server) RegisterRoutes() {
 
 	api := e.Group("/api/v1")
 
+	// Cookie-authenticated requests need a double-submit CSRF token
+	if s.Config.Auth.CookieMode {
+		api.Use(customMiddleware.CSRF(auth.RefreshCookieName, auth.CSRFCookieName))
+	}
+
 	// Swagger
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
 	// Auth Routes
 	s.AuthHandler.RegisterRoutes(api)
+	s.DeviceHandler.RegisterRoutes(api)
+
+	// Files of the local storage driver; S3 serves its own
+	if s.StorageHandler != nil {
+		s.StorageHandler.RegisterRoutes(api.Group("/files"))
+	}
+	s.UserHandler.RegisterPublicRoutes(api,
+		// Profile lookups get their own, stricter budget to discourage scraping
+		customMiddleware.RateLimitBucket(s.Redis, "profiles", s.Config.Profiles.RateLimit, 1*time.Minute),
+	)
 
 	// Protected Routes
 	protected := api.Group("")
-	protected.Use(customMiddleware.Auth(s.Config.JWTSecret))
-	s.UserHandler.RegisterRoutes(protected)
+	protected.Use(customMiddleware.Auth(s.Config.JWTSecret, s.DPoP))
+
+	// Reviewing terms, leaving and data access never depend on consent
+	s.UserHandler.RegisterConsentRoutes(protected)
+	s.UserHandler.RegisterAccountRoutes(protected)
+	s.ExportHandler.RegisterRoutes(protected)
+
+	// Everything else requires the latest mandatory terms to be accepted
+	consented := protected.Group("")
+	consented.Use(customMiddleware.RequireConsent(s.Consents))
+	s.UserHandler.RegisterRoutes(consented)
+	s.PreferencesHandler.RegisterRoutes(consented)
+	s.DeviceHandler.RegisterProtectedRoutes(consented)
+
+	// Admin Routes
+	admin := consented.Group("/admin")
+	admin.Use(customMiddleware.RequireRole(user.RoleAdmin))
+	s.UserHandler.RegisterAdminRoutes(admin)
 }
 
 func (s *Server) healthHandler(c echo.Context) error {
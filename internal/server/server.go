@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -11,8 +12,13 @@ import (
 	"template/internal/auth"
 	"template/internal/config"
 	"template/internal/database"
+	"template/internal/device"
+	"template/internal/dpop"
+	"template/internal/export"
 	customMiddleware "template/internal/middleware"
+	"template/internal/preferences"
 	"template/internal/redis"
+	"template/internal/storage"
 	"template/internal/user"
 
 	"github.com/labstack/echo/v4"
@@ -21,24 +27,40 @@ import (
 )
 
 type Server struct {
-	Echo        *echo.Echo
-	Config      *config.Config
-	DB          database.Service
-	Redis       *redis.Client
-	AuthHandler *auth.Handler
-	UserHandler *user.Handler
+	Echo               *echo.Echo
+	Config             *config.Config
+	DB                 database.Service
+	Redis              *redis.Client
+	DPoP               *dpop.Verifier
+	AuthHandler        *auth.Handler
+	DeviceHandler      *device.Handler
+	UserHandler        *user.Handler
+	ExportHandler      *export.Handler
+	PreferencesHandler *preferences.Handler
+	// StorageHandler is nil unless files are stored locally.
+	StorageHandler *storage.Handler
+	Consents       customMiddleware.ConsentChecker
 }
 
 func NewServer(
 	cfg *config.Config,
 	db database.Service,
 	redis *redis.Client,
+	dpopVerifier *dpop.Verifier,
 	authHandler *auth.Handler,
+	deviceHandler *device.Handler,
 	userHandler *user.Handler,
+	exportHandler *export.Handler,
+	preferencesHandler *preferences.Handler,
+	storageHandler *storage.Handler,
+	consents customMiddleware.ConsentChecker,
 ) *Server {
 	e := echo.New()
 	e.HideBanner = true
 
+	// Client IP, used for login events, new device alerts and rate limits
+	e.IPExtractor = ipExtractor(cfg.TrustedProxies)
+
 	// Logger
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	e.Use(customMiddleware.SlogLogger(logger))
@@ -49,24 +71,35 @@ func NewServer(
 	// Recover
 	e.Use(middleware.Recover())
 
-	// CORS
+	// CORS: browsers reject credentials for a wildcard origin, so cookie mode
+	// only allows the configured origins
+	allowOrigins := cfg.AllowedOrigins
+	if len(allowOrigins) == 0 {
+		allowOrigins = []string{"*"}
+	}
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
-		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
-		AllowCredentials: true,
+		AllowOrigins:     allowOrigins,
+		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, echo.HeaderXCSRFToken, dpop.HeaderName},
+		AllowCredentials: cfg.Auth.CookieMode,
 	}))
 
 	// Rate Limit (Global - 100 req/min)
 	e.Use(customMiddleware.RateLimit(redis, 100, 1*time.Minute))
 
 	s := &Server{
-		Echo:        e,
-		Config:      cfg,
-		DB:          db,
-		Redis:       redis,
-		AuthHandler: authHandler,
-		UserHandler: userHandler,
+		Echo:               e,
+		Config:             cfg,
+		DB:                 db,
+		Redis:              redis,
+		DPoP:               dpopVerifier,
+		AuthHandler:        authHandler,
+		DeviceHandler:      deviceHandler,
+		UserHandler:        userHandler,
+		ExportHandler:      exportHandler,
+		PreferencesHandler: preferencesHandler,
+		StorageHandler:     storageHandler,
+		Consents:           consents,
 	}
 
 	s.RegisterRoutes()
@@ -74,6 +107,24 @@ func NewServer(
 	return s
 }
 
+// ipExtractor reads the client IP from X-Forwarded-For only when the request
+// comes from one of the trusted proxies. Otherwise clients could pick their own.
+func ipExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, network := range trustedProxies {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func (s *Server) Start() error {
 	return s.Echo.Start(fmt.Sprintf(":%d", s.Config.Port))
 }
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPExtractorIgnoresUntrustedForwardedFor(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    []*net.IPNet
+		remoteAddr string
+		want       string
+	}{
+		{"no trusted proxies", nil, "10.0.0.2:1234", "10.0.0.2"},
+		{"untrusted peer", []*net.IPNet{proxies}, "192.168.1.2:1234", "192.168.1.2"},
+		{"trusted proxy", []*net.IPNet{proxies}, "10.0.0.2:1234", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+
+			if got := ipExtractor(tt.proxies)(req); got != tt.want {
+				t.Errorf("client IP = %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
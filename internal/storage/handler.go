@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"template/internal/json"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Handler serves objects of the local driver: public objects to anyone and the
+// rest through URLs created by SignedURL.
+type Handler struct {
+	local          *Local
+	maxUploadBytes int64
+}
+
+func NewHandler(local *Local, maxUploadBytes int64) *Handler {
+	return &Handler{
+		local:          local,
+		maxUploadBytes: maxUploadBytes,
+	}
+}
+
+func (h *Handler) RegisterRoutes(g *echo.Group) {
+	g.GET("/*", h.Download)
+	g.HEAD("/*", h.Download)
+	g.PUT("/*", h.Upload)
+}
+
+// Download godoc
+// @Summary Download a file
+// @Description Serve a stored file. Files outside the public prefixes require a signed URL.
+// @Tags files
+// @Produce octet-stream
+// @Param key path string true "Object key"
+// @Param expires query int false "Signature expiry (Unix time)"
+// @Param signature query string false "URL signature"
+// @Success 200 {file} file
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /files/{key} [get]
+func (h *Handler) Download(c echo.Context) error {
+	key, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return json.NotFound(c, "File not found")
+	}
+
+	public := h.local.IsPublic(key) && c.QueryParam("signature") == ""
+	if !public {
+		err = h.local.signer.Verify(http.MethodGet, key, c.QueryParams())
+		if err != nil {
+			return response.ErrorJSON(c, http.StatusForbidden, "INVALID_SIGNATURE", "Invalid or expired signature", nil)
+		}
+	}
+
+	obj, info, err := h.local.Get(c.Request().Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidKey) {
+			return json.NotFound(c, "File not found")
+		}
+		return json.InternalServerError(c, err)
+	}
+	defer obj.Close()
+
+	if public {
+		c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=86400")
+	} else {
+		c.Response().Header().Set(echo.HeaderCacheControl, "private, no-store")
+	}
+	c.Response().Header().Set(echo.HeaderContentType, info.ContentType)
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+
+	if rs, ok := obj.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), c.Request(), "", info.ModTime, rs)
+		return nil
+	}
+	return c.Stream(http.StatusOK, info.ContentType, obj)
+}
+
+// Upload godoc
+// @Summary Upload a file
+// @Description Store the request body under key through a signed upload URL
+// @Tags files
+// @Accept octet-stream
+// @Produce json
+// @Param key path string true "Object key"
+// @Param expires query int true "Signature expiry (Unix time)"
+// @Param signature query string true "URL signature"
+// @Success 201 {object} response.Response{data=storage.ObjectInfo}
+// @Failure 400 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 413 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /files/{key} [put]
+func (h *Handler) Upload(c echo.Context) error {
+	key, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_KEY", "Invalid file key", nil)
+	}
+
+	err = h.local.signer.Verify(http.MethodPut, key, c.QueryParams())
+	if err != nil {
+		return response.ErrorJSON(c, http.StatusForbidden, "INVALID_SIGNATURE", "Invalid or expired signature", nil)
+	}
+
+	ctx := c.Request().Context()
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, h.maxUploadBytes)
+
+	err = h.local.Put(ctx, key, body, c.Request().Header.Get(echo.HeaderContentType))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return response.ErrorJSON(c, http.StatusRequestEntityTooLarge, "FILE_TOO_LARGE", "File is too large", nil)
+		}
+		if errors.Is(err, ErrInvalidKey) {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_KEY", "Invalid file key", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	info, err := h.local.Stat(ctx, key)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusCreated, info, nil)
+}
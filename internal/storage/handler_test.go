@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func newTestHandler(t *testing.T) (*echo.Echo, *Local) {
+	t.Helper()
+	local, err := NewLocal(t.TempDir(), "http://localhost/files", []string{"avatars/"}, NewSigner("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, key := range []string{"avatars/a/64.jpg", "exports/u/export.zip"} {
+		err = local.Put(ctx, key, strings.NewReader("data"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := echo.New()
+	NewHandler(local, 1<<20).RegisterRoutes(e.Group("/files"))
+	return e, local
+}
+
+func TestDownloadRequiresSignatureOutsidePublicPrefixes(t *testing.T) {
+	e, local := newTestHandler(t)
+
+	signed, err := local.SignedURL(context.Background(), http.MethodGet, "exports/u/export.zip", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"public object", "/files/avatars/a/64.jpg", http.StatusOK},
+		{"private object", "/files/exports/u/export.zip", http.StatusForbidden},
+		{"signed private object", strings.TrimPrefix(signed, "http://localhost"), http.StatusOK},
+		{"traversal out of public prefix", "/files/avatars/../exports/u/export.zip", http.StatusForbidden},
+		{"escaped traversal", "/files/avatars%2F..%2Fexports%2Fu%2Fexport.zip", http.StatusForbidden},
+		{"dot segment", "/files/avatars/./a/64.jpg", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("GET %s = %d; want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalRejectsUncleanKeys(t *testing.T) {
+	_, local := newTestHandler(t)
+
+	for _, key := range []string{"", "/abs", "avatars/../exports/u/export.zip", "avatars//a", "a/./b", "a/"} {
+		if local.IsPublic(key) {
+			t.Errorf("IsPublic(%q) = true", key)
+		}
+		if _, _, err := local.Get(context.Background(), key); err != ErrInvalidKey {
+			t.Errorf("Get(%q) error = %v; want %v", key, err, ErrInvalidKey)
+		}
+	}
+}
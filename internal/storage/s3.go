@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// s3PartSize bounds the memory buffered per upload of unknown size.
+	s3PartSize = 16 << 20
+	// maxPresignTTL is the longest expiry S3 accepts for presigned URLs.
+	maxPresignTTL = 7 * 24 * time.Hour
+)
+
+type S3Options struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	PathStyle       bool
+	// PublicURL serves objects under public prefixes, e.g. a CDN in front of the
+	// bucket. If empty, URL returns presigned URLs.
+	PublicURL string
+}
+
+// S3 stores objects in a bucket of any S3-compatible service, such as AWS S3 or
+// MinIO. Signed URLs are presigned by the service itself.
+type S3 struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3(opts S3Options) (*S3, error) {
+	lookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, ""),
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{
+		client:    client,
+		bucket:    opts.Bucket,
+		publicURL: opts.PublicURL,
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	if key == "" {
+		return ErrInvalidKey
+	}
+
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+		PartSize:    s3PartSize,
+	})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s3Error(err)
+	}
+
+	// GetObject is lazy; Stat issues the request and surfaces missing keys
+	info, err := obj.Stat()
+	if err != nil {
+		_ = obj.Close()
+		return nil, nil, s3Error(err)
+	}
+
+	return obj, s3ObjectInfo(info), nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return s3ObjectInfo(info), nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	for info := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+		objects = append(objects, *s3ObjectInfo(info))
+	}
+
+	return objects, nil
+}
+
+func (s *S3) URL(ctx context.Context, key string) (string, error) {
+	if s.publicURL != "" {
+		return objectURL(s.publicURL, key), nil
+	}
+	return s.SignedURL(ctx, http.MethodGet, key, maxPresignTTL)
+}
+
+func (s *S3) SignedURL(ctx context.Context, method, key string, ttl time.Duration) (string, error) {
+	ttl = min(ttl, maxPresignTTL)
+
+	switch method {
+	case http.MethodGet:
+		u, err := s.client.PresignedGetObject(ctx, s.bucket, key, ttl, nil)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	case http.MethodPut:
+		u, err := s.client.PresignedPutObject(ctx, s.bucket, key, ttl)
+		if err != nil {
+			return "", err
+		}
+		return u.String(), nil
+	}
+
+	return "", ErrUnsupportedMethod
+}
+
+func s3Error(err error) error {
+	if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+		return ErrNotFound
+	}
+	return err
+}
+
+func s3ObjectInfo(info minio.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		Key:         info.Key,
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		ModTime:     info.LastModified,
+	}
+}
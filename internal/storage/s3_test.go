@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/testcontainers/testcontainers-go"
+	tcminio "github.com/testcontainers/testcontainers-go/modules/minio"
+)
+
+// newTestS3 starts MinIO in a container and returns an S3 driver for a fresh
+// bucket. The test is skipped without Docker.
+func newTestS3(t *testing.T) *S3 {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	container, err := tcminio.Run(ctx, "minio/minio:RELEASE.2024-01-16T16-07-38Z")
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s3, err := NewS3(S3Options{
+		Endpoint:        endpoint,
+		Region:          "us-east-1",
+		Bucket:          "test",
+		AccessKeyID:     container.Username,
+		SecretAccessKey: container.Password,
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s3.client.MakeBucket(ctx, s3.bucket, minio.MakeBucketOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s3
+}
+
+func TestS3AgainstMinIO(t *testing.T) {
+	s3 := newTestS3(t)
+	ctx := context.Background()
+
+	err := s3.Put(ctx, "avatars/u/64.jpg", strings.NewReader("avatar"), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s3.Stat(ctx, "avatars/u/64.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 6 || info.ContentType != "image/jpeg" {
+		t.Errorf("Stat() = %+v; want 6 bytes of image/jpeg", info)
+	}
+
+	obj, _, err := s3.Get(ctx, "avatars/u/64.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(obj)
+	_ = obj.Close()
+	if err != nil || string(body) != "avatar" {
+		t.Errorf("Get() = %q, %v; want %q", body, err, "avatar")
+	}
+
+	// Upload and download through presigned URLs, as clients do
+	putURL, err := s3.SignedURL(ctx, http.MethodPut, "exports/u/export.zip", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, putURL, strings.NewReader("export"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("presigned PUT status = %d", res.StatusCode)
+	}
+
+	getURL, err := s3.SignedURL(ctx, http.MethodGet, "exports/u/export.zip", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = http.Get(getURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil || string(body) != "export" {
+		t.Errorf("presigned GET = %q, %v; want %q", body, err, "export")
+	}
+
+	objects, err := s3.List(ctx, "exports/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "exports/u/export.zip" {
+		t.Errorf("List(exports/) = %+v; want exports/u/export.zip", objects)
+	}
+
+	err = s3.Delete(ctx, "avatars/u/64.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s3.Stat(ctx, "avatars/u/64.jpg")
+	if err != ErrNotFound {
+		t.Errorf("Stat() after Delete error = %v; want %v", err, ErrNotFound)
+	}
+	err = s3.Delete(ctx, "avatars/u/64.jpg")
+	if err != nil {
+		t.Errorf("Delete() of a missing object error = %v; want nil", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Package storage stores files behind a driver-agnostic interface.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound          = errors.New("object not found")
+	ErrInvalidKey        = errors.New("invalid object key")
+	ErrInvalidSignature  = errors.New("invalid or expired signature")
+	ErrUnsupportedMethod = errors.New("signed URLs support only GET and PUT")
+)
+
+type ObjectInfo struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+type Storage interface {
+	// Put streams an object from r, replacing any existing one with the same
+	// key.
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	// Get opens an object for reading. The caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Stat returns an object's metadata without reading it.
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// List returns every object whose key starts with prefix, sorted by key.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// URL returns a stable address for an object under a public prefix.
+	URL(ctx context.Context, key string) (string, error)
+	// SignedURL returns an address that allows method (GET or PUT) on key
+	// without further authentication until ttl has passed.
+	SignedURL(ctx context.Context, method, key string, ttl time.Duration) (string, error)
+}
+
+// cleanKey reports whether key is a relative slash-separated path without
+// empty, "." or ".." segments, so a prefix check on the key holds for the
+// object it names.
+func cleanKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// objectURL joins a base URL and an object key, escaping each key segment.
+func objectURL(baseURL, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.Join(segments, "/")
+}
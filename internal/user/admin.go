@@ -0,0 +1,226 @@
+package user
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrAccountSuspended = errors.New("account is suspended")
+	ErrUsernameTaken    = errors.New("username already taken")
+	ErrCannotModifySelf = errors.New("admins cannot suspend or delete themselves")
+	ErrUserNotDeleted   = errors.New("user is not deleted")
+)
+
+// User statuses for filtering the admin user list.
+const (
+	UserStatusActive          = "active"
+	UserStatusSuspended       = "suspended"
+	UserStatusGuest           = "guest"
+	UserStatusPendingDeletion = "pending_deletion"
+	UserStatusDeleted         = "deleted"
+)
+
+type ListUsersQuery struct {
+	PageQuery
+	// Search matches email or username, case-insensitively.
+	Search string `query:"q" validate:"omitempty,max=100"`
+	Role   string `query:"role" validate:"omitempty,oneof=user admin"`
+	Status string `query:"status" validate:"omitempty,oneof=active suspended guest pending_deletion deleted"`
+	Sort   string `query:"sort" validate:"omitempty,oneof=created_at last_login email username"`
+	Order  string `query:"order" validate:"omitempty,oneof=asc desc"`
+}
+
+type AdminUpdateUserRequest struct {
+	Email    *string `json:"email" validate:"omitempty,email"`
+	Username *string `json:"username" validate:"omitempty,min=3,max=50"`
+}
+
+// AdminUpdateUser changes a user's email and/or username without the
+// confirmation flows users go through themselves. Admins may assign reserved
+// usernames.
+func (s *service) AdminUpdateUser(ctx context.Context, userID string, req *AdminUpdateUserRequest) (*User, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Email != nil {
+		email := normalizeEmail(*req.Email)
+		if email != user.Email {
+			err = s.adminUpdateEmail(ctx, user.ID, email)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if req.Username != nil {
+		username := normalizeUsername(*req.Username)
+		if username != user.Username {
+			err = s.adminUpdateUsername(ctx, user.ID, username)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s.repo.GetByID(ctx, user.ID)
+}
+
+func (s *service) adminUpdateEmail(ctx context.Context, userID, email string) error {
+	existing, err := s.repo.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrUserAlreadyExists
+	}
+
+	return s.repo.UpdateEmail(ctx, userID, email)
+}
+
+func (s *service) adminUpdateUsername(ctx context.Context, userID, username string) error {
+	err := s.checkUsernameAvailable(ctx, userID, username)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.UpdateUsername(ctx, userID, username)
+}
+
+// SetSuspended suspends or unsuspends a user. Suspending also signs out every
+// session, and suspended users cannot log in or refresh.
+func (s *service) SetSuspended(ctx context.Context, actorID, userID string, suspended bool) error {
+	if actorID == userID {
+		return ErrCannotModifySelf
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.SetSuspended(ctx, user.ID, suspended)
+	if err != nil {
+		return err
+	}
+
+	if suspended {
+		return s.repo.RevokeAllUserTokens(ctx, user.ID)
+	}
+	return nil
+}
+
+// ForcePasswordReset signs the user out everywhere and makes them set a new
+// password through the emailed reset link before logging in again.
+func (s *service) ForcePasswordReset(ctx context.Context, userID string) error {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.SetPasswordResetRequired(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.RevokeAllUserTokens(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if user.IsGuest {
+		return nil
+	}
+	return s.sendResetEmail(user)
+}
+
+func (s *service) RevokeUserSessions(ctx context.Context, userID string) error {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.RevokeAllUserTokens(ctx, user.ID)
+}
+
+// AdminDeleteUser soft-deletes a user right away, without the grace period of
+// self-service deletion, and signs out every session. The user's data is kept
+// until RestoreUser or PurgeUser.
+func (s *service) AdminDeleteUser(ctx context.Context, actorID, userID string) error {
+	if actorID == userID {
+		return ErrCannotModifySelf
+	}
+
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.SoftDeleteUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.RevokeAllUserTokens(ctx, user.ID)
+}
+
+// RestoreUser brings back a soft-deleted user. Sessions revoked on deletion stay
+// revoked.
+func (s *service) RestoreUser(ctx context.Context, userID string) (*User, error) {
+	restored, err := s.repo.RestoreUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !restored {
+		return nil, s.notDeletedError(ctx, userID)
+	}
+
+	return s.getUser(ctx, userID)
+}
+
+// PurgeUser permanently deletes a soft-deleted user along with their avatar.
+func (s *service) PurgeUser(ctx context.Context, userID string) error {
+	user, err := s.repo.GetDeletedByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return s.notDeletedError(ctx, userID)
+	}
+
+	err = s.repo.DeleteUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	if user.AvatarKey != "" {
+		s.deleteAvatar(ctx, user.AvatarKey)
+	}
+	return nil
+}
+
+// notDeletedError tells a live user, reported as ErrUserNotDeleted, from one
+// that does not exist.
+func (s *service) notDeletedError(ctx context.Context, userID string) error {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	return ErrUserNotDeleted
+}
+
+func (s *service) getUser(ctx context.Context, userID string) (*User, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
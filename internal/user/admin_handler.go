@@ -0,0 +1,552 @@
+package user
+
+import (
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/jwt"
+	"template/internal/response"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// RegisterAdminRoutes registers routes that must be mounted behind admin-only
+// middleware.
+func (h *Handler) RegisterAdminRoutes(g *echo.Group) {
+	g.POST("/invites", h.CreateInvite)
+	g.GET("/invites", h.ListInvites)
+	g.DELETE("/invites/:id", h.RevokeInvite)
+	g.POST("/legal-documents", h.PublishLegalDocument)
+
+	g.GET("/users", h.ListUsers)
+	g.GET("/users/search", h.SearchUsers)
+	g.POST("/users/import", h.ImportUsers)
+	g.GET("/users/export", h.ExportUsers)
+	g.GET("/users/:id", h.GetUser)
+	g.PATCH("/users/:id", h.UpdateUser)
+	g.POST("/users/:id/suspend", h.SuspendUser)
+	g.POST("/users/:id/unsuspend", h.UnsuspendUser)
+	g.POST("/users/:id/force-password-reset", h.ForcePasswordReset)
+	g.DELETE("/users/:id/sessions", h.RevokeUserSessions)
+	g.DELETE("/users/:id", h.DeleteUser)
+	g.POST("/users/:id/restore", h.RestoreUser)
+	g.DELETE("/users/:id/purge", h.PurgeUser)
+}
+
+// CreateInvite godoc
+// @Summary Create an invite
+// @Description Create a single- or multi-use invite code for invite-only registration
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.CreateInviteRequest true "Create Invite Request"
+// @Success 201 {object} response.Response{data=user.Invite}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/invites [post]
+func (h *Handler) CreateInvite(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var req CreateInviteRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	invite, err := h.service.CreateInvite(c.Request().Context(), claims.UserID, &req)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusCreated, invite, nil)
+}
+
+// ListInvites godoc
+// @Summary List invites
+// @Description List invite codes, newest first
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number" minimum(1)
+// @Param per_page query int false "Items per page" minimum(1) maximum(100)
+// @Success 200 {object} response.Response{data=[]user.Invite,meta=response.PageMeta}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/invites [get]
+func (h *Handler) ListInvites(c echo.Context) error {
+	var q PageQuery
+	if err := c.Bind(&q); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(q); err != nil {
+		return json.BadRequest(c, err)
+	}
+	q.Normalize()
+
+	invites, total, err := h.repo.ListInvites(c.Request().Context(), q.PerPage, q.Offset())
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, invites, response.PageMeta{Page: q.Page, PerPage: q.PerPage, Total: total})
+}
+
+// RevokeInvite godoc
+// @Summary Revoke an invite
+// @Description Revoke an invite code so it can no longer be used
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Invite ID"
+// @Success 200 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/invites/{id} [delete]
+func (h *Handler) RevokeInvite(c echo.Context) error {
+	found, err := h.repo.RevokeInvite(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+	if !found {
+		return json.NotFound(c, "Invite not found")
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Invite revoked"}, nil)
+}
+
+// userIDParam returns the :id path parameter, reporting false if it is not a
+// valid user ID.
+func userIDParam(c echo.Context) (string, bool) {
+	id := c.Param("id")
+	if err := uuid.Validate(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+// adminUserError maps errors of the admin user operations to responses.
+func adminUserError(c echo.Context, err error) error {
+	switch err {
+	case ErrUserNotFound:
+		return json.NotFound(c, "User not found")
+	case ErrUserAlreadyExists:
+		return response.ErrorJSON(c, http.StatusConflict, "USER_ALREADY_EXISTS", "User with this email already exists", nil)
+	case ErrUsernameTaken:
+		return response.ErrorJSON(c, http.StatusConflict, "USERNAME_TAKEN", "Username is already taken", nil)
+	case ErrUsernameConfusable:
+		return response.ErrorJSON(c, http.StatusConflict, "USERNAME_CONFUSABLE", "Username is too similar to an existing username", nil)
+	case ErrInvalidUsername:
+		return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_USERNAME", "Username must be 3 to 50 letters of one script, digits, '_', '-' or '.'", nil)
+	case ErrCannotModifySelf:
+		return response.ErrorJSON(c, http.StatusBadRequest, "CANNOT_MODIFY_SELF", "Admins cannot suspend or delete their own account", nil)
+	case ErrUserNotDeleted:
+		return response.ErrorJSON(c, http.StatusConflict, "USER_NOT_DELETED", "User is not deleted", nil)
+	default:
+		return json.InternalServerError(c, err)
+	}
+}
+
+// ListUsers godoc
+// @Summary List users
+// @Description List users with filtering, sorting and pagination
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number" minimum(1)
+// @Param per_page query int false "Items per page" minimum(1) maximum(100)
+// @Param q query string false "Search email or username"
+// @Param role query string false "Filter by role" Enums(user, admin)
+// @Param status query string false "Filter by status" Enums(active, suspended, guest, pending_deletion, deleted)
+// @Param sort query string false "Sort field" Enums(created_at, last_login, email, username)
+// @Param order query string false "Sort order" Enums(asc, desc)
+// @Success 200 {object} response.Response{data=[]user.User,meta=response.PageMeta}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users [get]
+func (h *Handler) ListUsers(c echo.Context) error {
+	var q ListUsersQuery
+	if err := c.Bind(&q); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(q); err != nil {
+		return json.BadRequest(c, err)
+	}
+	q.Normalize()
+
+	users, total, err := h.repo.ListUsers(c.Request().Context(), &q)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	for i := range users {
+		err = h.withAvatarURLs(c.Request().Context(), &users[i])
+		if err != nil {
+			return json.InternalServerError(c, err)
+		}
+	}
+
+	return response.JSON(c, http.StatusOK, users, response.PageMeta{Page: q.Page, PerPage: q.PerPage, Total: total})
+}
+
+// SearchUsers godoc
+// @Summary Search users
+// @Description Find users by partial or misspelled email, username or display name, best matches first. Supports web search syntax such as quoted phrases. Matching fields are returned with <mark> highlights.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param q query string true "Search query"
+// @Param cursor query string false "Cursor from the previous page"
+// @Param limit query int false "Results per page" default(20)
+// @Success 200 {object} response.Response{data=[]user.UserSearchResult,meta=response.CursorMeta}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/search [get]
+func (h *Handler) SearchUsers(c echo.Context) error {
+	var q SearchUsersQuery
+	if err := c.Bind(&q); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	q.Normalize()
+	if err := h.validator.Validate(q); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	results, next, err := h.repo.Search(c.Request().Context(), &q)
+	if err != nil {
+		if err == ErrInvalidCursor {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_CURSOR", "Invalid cursor", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	for i := range results {
+		results[i].Highlight(q.Q)
+		err = h.withAvatarURLs(c.Request().Context(), &results[i].User)
+		if err != nil {
+			return json.InternalServerError(c, err)
+		}
+	}
+
+	return response.JSON(c, http.StatusOK, results, response.CursorMeta{Limit: q.Limit, NextCursor: next})
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Get any user by ID
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response{data=user.User}
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id} [get]
+func (h *Handler) GetUser(c echo.Context) error {
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	user, err := h.repo.GetByID(c.Request().Context(), id)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+	if user == nil {
+		return json.NotFound(c, "User not found")
+	}
+
+	if err := h.withAvatarURLs(c.Request().Context(), user); err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, user, nil)
+}
+
+// UpdateUser godoc
+// @Summary Update a user
+// @Description Change a user's email and/or username without confirmation
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param request body user.AdminUpdateUserRequest true "Update User Request"
+// @Success 200 {object} response.Response{data=user.User}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id} [patch]
+func (h *Handler) UpdateUser(c echo.Context) error {
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	var req AdminUpdateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	user, err := h.service.AdminUpdateUser(c.Request().Context(), id, &req)
+	if err != nil {
+		return adminUserError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), user.ID, user.Username)
+
+	if err := h.withAvatarURLs(c.Request().Context(), user); err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, user, nil)
+}
+
+// SuspendUser godoc
+// @Summary Suspend a user
+// @Description Block a user from logging in or refreshing and sign out all their sessions
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id}/suspend [post]
+func (h *Handler) SuspendUser(c echo.Context) error {
+	return h.setSuspended(c, true)
+}
+
+// UnsuspendUser godoc
+// @Summary Unsuspend a user
+// @Description Allow a suspended user to log in again
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id}/unsuspend [post]
+func (h *Handler) UnsuspendUser(c echo.Context) error {
+	return h.setSuspended(c, false)
+}
+
+func (h *Handler) setSuspended(c echo.Context, suspended bool) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	err := h.service.SetSuspended(c.Request().Context(), claims.UserID, id, suspended)
+	if err != nil {
+		return adminUserError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), id, "")
+
+	message := "User unsuspended"
+	if suspended {
+		message = "User suspended"
+	}
+	return response.JSON(c, http.StatusOK, map[string]string{"message": message}, nil)
+}
+
+// ForcePasswordReset godoc
+// @Summary Force a password reset
+// @Description Sign the user out everywhere and require a new password, set through an emailed reset link
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id}/force-password-reset [post]
+func (h *Handler) ForcePasswordReset(c echo.Context) error {
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	err := h.service.ForcePasswordReset(c.Request().Context(), id)
+	if err != nil {
+		return adminUserError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Password reset required, a reset link has been sent"}, nil)
+}
+
+// RevokeUserSessions godoc
+// @Summary Revoke a user's sessions
+// @Description Sign a user out of every session
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id}/sessions [delete]
+func (h *Handler) RevokeUserSessions(c echo.Context) error {
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	err := h.service.RevokeUserSessions(c.Request().Context(), id)
+	if err != nil {
+		return adminUserError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "All sessions revoked"}, nil)
+}
+
+// DeleteUser godoc
+// @Summary Delete a user
+// @Description Soft-delete a user immediately and sign out all their sessions. The user can be restored until purged.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id} [delete]
+func (h *Handler) DeleteUser(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	err := h.service.AdminDeleteUser(c.Request().Context(), claims.UserID, id)
+	if err != nil {
+		return adminUserError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), id, "")
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "User deleted"}, nil)
+}
+
+// RestoreUser godoc
+// @Summary Restore a user
+// @Description Undo the soft delete of a user. Fails if their email or username has been taken since.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response{data=user.User}
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id}/restore [post]
+func (h *Handler) RestoreUser(c echo.Context) error {
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	user, err := h.service.RestoreUser(c.Request().Context(), id)
+	if err != nil {
+		return adminUserError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), user.ID, user.Username)
+
+	if err := h.withAvatarURLs(c.Request().Context(), user); err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, user, nil)
+}
+
+// PurgeUser godoc
+// @Summary Purge a user
+// @Description Permanently delete a soft-deleted user and all their data
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/{id}/purge [delete]
+func (h *Handler) PurgeUser(c echo.Context) error {
+	id, ok := userIDParam(c)
+	if !ok {
+		return json.NotFound(c, "User not found")
+	}
+
+	err := h.service.PurgeUser(c.Request().Context(), id)
+	if err != nil {
+		return adminUserError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "User purged"}, nil)
+}
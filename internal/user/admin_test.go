@@ -0,0 +1,47 @@
+package user
+
+import (
+	"context"
+	"testing"
+
+	"template/internal/config"
+)
+
+func TestAdminSoftDeleteRestoreAndPurge(t *testing.T) {
+	u := existingUser(t)
+	repo := newFakeRepo(u)
+	s := newTestService(repo, &fakeMailer{}, config.AuthConfig{})
+	ctx := context.Background()
+
+	if err := s.AdminDeleteUser(ctx, u.ID, u.ID); err != ErrCannotModifySelf {
+		t.Fatalf("AdminDeleteUser(self) error = %v; want %v", err, ErrCannotModifySelf)
+	}
+	if err := s.PurgeUser(ctx, u.ID); err != ErrUserNotDeleted {
+		t.Fatalf("PurgeUser(live user) error = %v; want %v", err, ErrUserNotDeleted)
+	}
+	if _, err := s.RestoreUser(ctx, u.ID); err != ErrUserNotDeleted {
+		t.Fatalf("RestoreUser(live user) error = %v; want %v", err, ErrUserNotDeleted)
+	}
+
+	if err := s.AdminDeleteUser(ctx, "admin", u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.getUser(ctx, u.ID); err != ErrUserNotFound {
+		t.Errorf("getUser(deleted user) error = %v; want %v", err, ErrUserNotFound)
+	}
+
+	restored, err := s.RestoreUser(ctx, u.ID)
+	if err != nil || restored.ID != u.ID {
+		t.Fatalf("RestoreUser() = %v, %v; want the user back", restored, err)
+	}
+
+	if err = s.AdminDeleteUser(ctx, "admin", u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.PurgeUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.PurgeUser(ctx, u.ID); err != ErrUserNotFound {
+		t.Errorf("PurgeUser(purged user) error = %v; want %v", err, ErrUserNotFound)
+	}
+}
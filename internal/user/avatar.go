@@ -0,0 +1,203 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+
+	// Register decoders for image.Decode
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// MaxAvatarBytes is the largest avatar upload accepted.
+const MaxAvatarBytes = 5 << 20
+
+// maxAvatarDimension bounds the decoded image so small files cannot expand into
+// huge bitmaps.
+const maxAvatarDimension = 8000
+
+// avatarSizes are the square sizes generated for every avatar, in pixels. The
+// largest is returned as avatar_url.
+var avatarSizes = []int{64, 128, 256, 512}
+
+// allowedAvatarTypes are accepted by content sniffing, not by the declared type.
+var allowedAvatarTypes = map[string]struct{}{
+	"image/jpeg": {},
+	"image/png":  {},
+	"image/gif":  {},
+}
+
+var (
+	ErrAvatarTooLarge    = errors.New("avatar too large")
+	ErrUnsupportedAvatar = errors.New("unsupported avatar image")
+)
+
+// UpdateAvatar validates an uploaded image and stores it as a set of square
+// JPEG thumbnails. Re-encoding drops all metadata, including EXIF, once the
+// EXIF orientation has been applied. The previous avatar is deleted afterwards.
+func (s *service) UpdateAvatar(ctx context.Context, userID string, data []byte) (*User, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeAvatar(data)
+	if err != nil {
+		return nil, err
+	}
+
+	version := make([]byte, 8)
+	_, err = rand.Read(version)
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("avatars/%s/%s", user.ID, hex.EncodeToString(version))
+
+	square := cropSquare(img)
+	for _, size := range avatarSizes {
+		var encoded []byte
+		encoded, err = encodeThumbnail(square, size)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.storage.Put(ctx, avatarObjectKey(prefix, size), bytes.NewReader(encoded), "image/jpeg")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.repo.UpdateAvatar(ctx, user.ID, prefix)
+	if err != nil {
+		s.deleteAvatar(ctx, prefix)
+		return nil, err
+	}
+
+	if user.AvatarKey != "" {
+		s.deleteAvatar(ctx, user.AvatarKey)
+	}
+
+	user.AvatarKey = prefix
+	return user, nil
+}
+
+func (s *service) RemoveAvatar(ctx context.Context, userID string) error {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.AvatarKey == "" {
+		return nil
+	}
+
+	err = s.repo.UpdateAvatar(ctx, user.ID, "")
+	if err != nil {
+		return err
+	}
+
+	s.deleteAvatar(ctx, user.AvatarKey)
+	return nil
+}
+
+// AvatarURLs resolves the URL of the largest avatar size and of every thumbnail,
+// keyed by size. Both are empty if the user has no avatar.
+func (s *service) AvatarURLs(ctx context.Context, avatarKey string) (string, map[string]string, error) {
+	if avatarKey == "" {
+		return "", nil, nil
+	}
+
+	thumbnails := make(map[string]string, len(avatarSizes))
+	for _, size := range avatarSizes {
+		url, err := s.storage.URL(ctx, avatarObjectKey(avatarKey, size))
+		if err != nil {
+			return "", nil, err
+		}
+		thumbnails[strconv.Itoa(size)] = url
+	}
+
+	return thumbnails[strconv.Itoa(avatarSizes[len(avatarSizes)-1])], thumbnails, nil
+}
+
+// deleteAvatar removes every size of an avatar. Failures only leave orphaned
+// files behind, so they are ignored.
+func (s *service) deleteAvatar(ctx context.Context, prefix string) {
+	for _, size := range avatarSizes {
+		_ = s.storage.Delete(ctx, avatarObjectKey(prefix, size))
+	}
+}
+
+func avatarObjectKey(prefix string, size int) string {
+	return fmt.Sprintf("%s/%d.jpg", prefix, size)
+}
+
+func decodeAvatar(data []byte) (image.Image, error) {
+	if len(data) > MaxAvatarBytes {
+		return nil, ErrAvatarTooLarge
+	}
+
+	if _, ok := allowedAvatarTypes[http.DetectContentType(data)]; !ok {
+		return nil, ErrUnsupportedAvatar
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedAvatar
+	}
+	if cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		return nil, ErrAvatarTooLarge
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedAvatar
+	}
+
+	// Re-encoding drops EXIF, so the orientation it records is applied first
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	return img, nil
+}
+
+// cropSquare returns the centered square of img.
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	rect := image.Rect(x, y, x+side, y+side)
+
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	return img
+}
+
+// encodeThumbnail scales a square image to size and encodes it as JPEG,
+// flattening transparency onto white.
+func encodeThumbnail(img image.Image, size int) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
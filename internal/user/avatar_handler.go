@@ -0,0 +1,124 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/jwt"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// multipartOverhead allows for the multipart framing around the avatar file.
+const multipartOverhead = 64 << 10
+
+// UpdateAvatar godoc
+// @Summary Upload avatar
+// @Description Replace the current user's avatar. The image type is detected from its content; JPEG, PNG and GIF are accepted. Metadata is stripped and square thumbnails are generated.
+// @Tags users
+// @Accept multipart/form-data
+// @Produce json
+// @Security ApiKeyAuth
+// @Param avatar formData file true "Avatar image"
+// @Success 200 {object} response.Response{data=user.User}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 413 {object} response.Response
+// @Failure 415 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/avatar [put]
+func (h *Handler) UpdateAvatar(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, MaxAvatarBytes+multipartOverhead)
+
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return avatarError(c, ErrAvatarTooLarge)
+		}
+		return json.BadRequest(c, err)
+	}
+	if file.Size > MaxAvatarBytes {
+		return avatarError(c, ErrAvatarTooLarge)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, MaxAvatarBytes+1))
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	user, err := h.service.UpdateAvatar(req.Context(), claims.UserID, data)
+	if err != nil {
+		return avatarError(c, err)
+	}
+	h.profiles.Invalidate(req.Context(), user.ID, user.Username)
+
+	err = h.withAvatarURLs(req.Context(), user)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, user, nil)
+}
+
+// RemoveAvatar godoc
+// @Summary Remove avatar
+// @Description Delete the current user's avatar and all of its thumbnails
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/avatar [delete]
+func (h *Handler) RemoveAvatar(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	err := h.service.RemoveAvatar(c.Request().Context(), claims.UserID)
+	if err != nil {
+		return avatarError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), claims.UserID, "")
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Avatar removed"}, nil)
+}
+
+// withAvatarURLs fills in the avatar URLs of a user loaded from the database.
+func (h *Handler) withAvatarURLs(ctx context.Context, user *User) error {
+	var err error
+	user.AvatarURL, user.AvatarThumbnails, err = h.service.AvatarURLs(ctx, user.AvatarKey)
+	return err
+}
+
+func avatarError(c echo.Context, err error) error {
+	switch err {
+	case ErrAvatarTooLarge:
+		return response.ErrorJSON(c, http.StatusRequestEntityTooLarge, "AVATAR_TOO_LARGE", "Avatar must be at most 5 MB and 8000x8000 pixels", nil)
+	case ErrUnsupportedAvatar:
+		return response.ErrorJSON(c, http.StatusUnsupportedMediaType, "UNSUPPORTED_AVATAR", "Avatar must be a JPEG, PNG or GIF image", nil)
+	case ErrUserNotFound:
+		return json.NotFound(c, "User not found")
+	}
+	return json.InternalServerError(c, err)
+}
@@ -0,0 +1,92 @@
+package user
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// orientedJPEG encodes a 32x16 image, red on the left half and blue on the
+// right, with an EXIF orientation in the given byte order.
+func orientedJPEG(t *testing.T, orientation uint16, order binary.ByteOrder) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 16 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	// TIFF header, IFD0 with one SHORT orientation entry, no next IFD
+	tiff := make([]byte, 26)
+	copy(tiff, "MM")
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], exifOrientationTag)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func TestDecodeAvatarAppliesOrientation(t *testing.T) {
+	tests := []struct {
+		orientation uint16
+		order       binary.ByteOrder
+		width       int
+		redAt       image.Point
+	}{
+		{1, binary.BigEndian, 32, image.Pt(4, 8)},
+		{2, binary.LittleEndian, 32, image.Pt(28, 8)},
+		{3, binary.BigEndian, 32, image.Pt(28, 8)},
+		{6, binary.BigEndian, 16, image.Pt(8, 4)},
+		{6, binary.LittleEndian, 16, image.Pt(8, 4)},
+		{8, binary.BigEndian, 16, image.Pt(8, 28)},
+	}
+
+	for _, tt := range tests {
+		data := orientedJPEG(t, tt.orientation, tt.order)
+		if got := jpegOrientation(data); got != int(tt.orientation) {
+			t.Errorf("jpegOrientation() = %d; want %d", got, tt.orientation)
+		}
+
+		img, err := decodeAvatar(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != tt.width {
+			t.Errorf("orientation %d: width = %d; want %d", tt.orientation, img.Bounds().Dx(), tt.width)
+		}
+		if !isRed(img.At(tt.redAt.X, tt.redAt.Y)) {
+			t.Errorf("orientation %d: pixel at %v is %v; want red", tt.orientation, tt.redAt, img.At(tt.redAt.X, tt.redAt.Y))
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package user
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"template/internal/jwt"
+	"template/internal/validator"
+)
+
+// Import formats.
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+)
+
+// How imported rows whose email or username already exists are handled.
+const (
+	// DuplicateSkip leaves existing users untouched and reports the rows as
+	// skipped.
+	DuplicateSkip = "skip"
+	// DuplicateUpdate updates the username, display name and role of users
+	// matched by email.
+	DuplicateUpdate = "update"
+	// DuplicateFail imports nothing if any row is a duplicate.
+	DuplicateFail = "fail"
+)
+
+// MaxImportRows bounds a single import.
+const MaxImportRows = 100_000
+
+// inviteQueueSize bounds how many imports can wait for their invitations to be
+// sent.
+const inviteQueueSize = 8
+
+var (
+	ErrImportTooLarge    = errors.New("too many rows to import")
+	ErrInvalidImportFile = errors.New("invalid import file")
+)
+
+type ImportOptions struct {
+	Format      string `query:"format" validate:"required,oneof=csv ndjson"`
+	OnDuplicate string `query:"on_duplicate" validate:"omitempty,oneof=skip update fail"`
+	// DryRun validates and reports without changing anything.
+	DryRun bool `query:"dry_run"`
+	// SendInvites emails created users a link to set their password.
+	SendInvites bool `query:"send_invites"`
+}
+
+// ImportRow is one user to import. Imported users have no password until they
+// set one through an invitation or password reset.
+type ImportRow struct {
+	Line        int    `json:"-"`
+	Email       string `json:"email" validate:"required,email,max=255"`
+	Username    string `json:"username" validate:"required,min=3,max=50"`
+	DisplayName string `json:"display_name" validate:"max=100"`
+	Role        string `json:"role" validate:"oneof=user admin"`
+}
+
+type ImportRowError struct {
+	Line  int    `json:"line"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportReport struct {
+	DryRun  bool `json:"dry_run"`
+	Total   int  `json:"total"`
+	Created int  `json:"created"`
+	Updated int  `json:"updated"`
+	Skipped int  `json:"skipped"`
+	Failed  int  `json:"failed"`
+	// Invited is the number of invitation emails being sent.
+	Invited int `json:"invited"`
+	// InvitesDropped is the number of invitations not sent because too many
+	// imports were waiting for theirs. These users can recover their password.
+	InvitesDropped int              `json:"invites_dropped,omitempty"`
+	Errors         []ImportRowError `json:"errors"`
+}
+
+// ImportResult is what an import changed in the database.
+type ImportResult struct {
+	Created []ImportedUser
+	Updated []ImportedUser
+	Skipped int
+	Errors  []ImportRowError
+}
+
+type ImportedUser struct {
+	ID       string
+	Email    string
+	Username string
+}
+
+// ParseImport reads and validates users from CSV, with a header row naming the
+// columns, or from newline-delimited JSON objects. Invalid rows, including
+// repeated emails or usernames, are returned as row errors; only unreadable
+// files are an error.
+func ParseImport(r io.Reader, format string, v *validator.Validator) ([]ImportRow, []ImportRowError, error) {
+	var rows []ImportRow
+	var rowErrors []ImportRowError
+	var err error
+
+	switch format {
+	case ImportFormatCSV:
+		rows, rowErrors, err = parseImportCSV(r)
+	case ImportFormatNDJSON:
+		rows, rowErrors, err = parseImportNDJSON(r)
+	default:
+		return nil, nil, fmt.Errorf("%w: unknown format %q", ErrInvalidImportFile, format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	valid := make([]ImportRow, 0, len(rows))
+	emails := make(map[string]int)
+	usernames := make(map[string]int)
+	for _, row := range rows {
+		row.Email = normalizeEmail(row.Email)
+		row.Username = normalizeUsername(row.Username)
+		row.DisplayName = strings.TrimSpace(row.DisplayName)
+		if row.Role == "" {
+			row.Role = RoleUser
+		}
+
+		err = v.Validate(row)
+		if err == nil {
+			err = validateUsername(row.Username)
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Line: row.Line, Email: row.Email, Error: err.Error()})
+			continue
+		}
+
+		if line, ok := emails[row.Email]; ok {
+			rowErrors = append(rowErrors, ImportRowError{Line: row.Line, Email: row.Email, Error: fmt.Sprintf("email repeats line %d", line)})
+			continue
+		}
+		if line, ok := usernames[row.Username]; ok {
+			rowErrors = append(rowErrors, ImportRowError{Line: row.Line, Email: row.Email, Error: fmt.Sprintf("username repeats line %d", line)})
+			continue
+		}
+		emails[row.Email] = row.Line
+		usernames[row.Username] = row.Line
+
+		valid = append(valid, row)
+	}
+
+	return valid, rowErrors, nil
+}
+
+func parseImportCSV(r io.Reader) ([]ImportRow, []ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: missing header row", ErrInvalidImportFile)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"email", "username"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("%w: missing %q column", ErrInvalidImportFile, required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	var rows []ImportRow
+	for {
+		var record []string
+		record, err = reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %w", ErrInvalidImportFile, err)
+		}
+		if len(rows) == MaxImportRows {
+			return nil, nil, ErrImportTooLarge
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, ImportRow{
+			Line:        line,
+			Email:       field(record, "email"),
+			Username:    field(record, "username"),
+			DisplayName: field(record, "display_name"),
+			Role:        field(record, "role"),
+		})
+	}
+
+	return rows, nil, nil
+}
+
+func parseImportNDJSON(r io.Reader) ([]ImportRow, []ImportRowError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var rows []ImportRow
+	var rowErrors []ImportRowError
+	for line := 1; scanner.Scan(); line++ {
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		if len(rows)+len(rowErrors) == MaxImportRows {
+			return nil, nil, ErrImportTooLarge
+		}
+
+		// Unknown fields are ignored so exports can be imported again
+		row := ImportRow{Line: line}
+		if err := json.Unmarshal([]byte(data), &row); err != nil {
+			rowErrors = append(rowErrors, ImportRowError{Line: line, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidImportFile, err)
+	}
+
+	return rows, rowErrors, nil
+}
+
+// ImportUsers imports rows parsed by ParseImport. rowErrors are the rows
+// ParseImport rejected, so the report covers the whole file. With DryRun the
+// import runs in a transaction that is rolled back, so the report reflects
+// exactly what would happen.
+func (s *service) ImportUsers(ctx context.Context, rows []ImportRow, rowErrors []ImportRowError, opts *ImportOptions) (*ImportReport, *ImportResult, error) {
+	onDuplicate := opts.OnDuplicate
+	if onDuplicate == "" {
+		onDuplicate = DuplicateSkip
+	}
+
+	result := &ImportResult{}
+	if len(rows) > 0 {
+		var err error
+		result, err = s.repo.ImportUsers(ctx, rows, onDuplicate, opts.DryRun)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	report := &ImportReport{
+		DryRun:  opts.DryRun,
+		Total:   len(rows) + len(rowErrors),
+		Created: len(result.Created),
+		Updated: len(result.Updated),
+		Skipped: result.Skipped,
+		Errors:  append(rowErrors, result.Errors...),
+	}
+	report.Failed = len(report.Errors)
+	if report.Errors == nil {
+		report.Errors = []ImportRowError{}
+	}
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Line < report.Errors[j].Line })
+	if opts.SendInvites && !opts.DryRun {
+		report.Invited = len(result.Created)
+	}
+
+	return report, result, nil
+}
+
+// ExportUsers writes every registered user to w as newline-delimited JSON, in
+// a shape ImportUsers accepts again.
+func (s *service) ExportUsers(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return s.repo.StreamUsers(ctx, func(user *User) error {
+		return enc.Encode(user)
+	})
+}
+
+// InviteImportedUsers emails each user a link to set their password. It
+// returns how many emails were sent and the failures joined together, and
+// stops early if ctx is cancelled.
+func (s *service) InviteImportedUsers(ctx context.Context, users []ImportedUser) (int, error) {
+	sent := 0
+	var errs []error
+	for _, u := range users {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+
+		err := s.sendImportInvite(u)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invite %s: %w", u.Email, err))
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+func (s *service) sendImportInvite(u ImportedUser) error {
+	token, err := jwt.GenerateImportInviteToken(u.ID, s.jwtSecret, s.authConfig.ImportInviteTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.frontendHost, token)
+	body := fmt.Sprintf("An account has been created for you. <a href=\"%s\">Set your password</a> to sign in.", link)
+	return s.emailSender.Send(u.Email, "Your New Account", body)
+}
+
+// QueueImportInvites hands the invitations of an import to RunInviteWorker
+// without waiting for them to be sent. It reports false, queuing nothing, when
+// too many imports are already waiting.
+func (s *service) QueueImportInvites(users []ImportedUser) bool {
+	select {
+	case s.inviteQueue <- users:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunInviteWorker sends queued import invitations one import at a time until
+// ctx is done, logging how many of each failed.
+func (s *service) RunInviteWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case users := <-s.inviteQueue:
+			sent, err := s.InviteImportedUsers(ctx, users)
+			if err != nil {
+				log.Printf("import invitations: %d of %d failed: %v", len(users)-sent, len(users), err)
+				continue
+			}
+			log.Printf("import invitations: %d sent", sent)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxImportBytes bounds the size of an uploaded import file.
+const maxImportBytes = 50 << 20
+
+// ImportUsers godoc
+// @Summary Import users
+// @Description Create users in bulk from a CSV file with a header row (email, username, display_name, role) or from newline-delimited JSON. Invalid and duplicate rows are reported per line. Imported users have no password until they follow the optional invitation email or reset their password.
+// @Tags admin
+// @Accept text/csv,application/x-ndjson
+// @Produce json
+// @Security ApiKeyAuth
+// @Param format query string true "csv or ndjson"
+// @Param on_duplicate query string false "skip (default), update or fail"
+// @Param dry_run query bool false "Validate and report without importing"
+// @Param send_invites query bool false "Email created users a link to set their password"
+// @Success 200 {object} response.Response{data=user.ImportReport}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 413 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/users/import [post]
+func (h *Handler) ImportUsers(c echo.Context) error {
+	// Bind only reads the body for POST requests
+	var opts ImportOptions
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, &opts); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(opts); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, maxImportBytes)
+	rows, rowErrors, err := ParseImport(body, opts.Format, h.validator)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || err == ErrImportTooLarge {
+			return response.ErrorJSON(c, http.StatusRequestEntityTooLarge, "IMPORT_TOO_LARGE", "Import files are limited to 50 MB and 100,000 rows", nil)
+		}
+		if errors.Is(err, ErrInvalidImportFile) {
+			return json.BadRequest(c, err)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	report, result, err := h.service.ImportUsers(c.Request().Context(), rows, rowErrors, &opts)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+	if !opts.DryRun {
+		for _, u := range result.Updated {
+			h.profiles.Invalidate(c.Request().Context(), u.ID, u.Username)
+		}
+	}
+
+	// Sending thousands of emails must not hold up the response
+	if report.Invited > 0 && !h.service.QueueImportInvites(result.Created) {
+		report.InvitesDropped = report.Invited
+		report.Invited = 0
+	}
+
+	return response.JSON(c, http.StatusOK, report, nil)
+}
+
+// ExportUsers godoc
+// @Summary Export users
+// @Description Stream every registered user as newline-delimited JSON, which the import endpoint accepts
+// @Tags admin
+// @Produce application/x-ndjson
+// @Security ApiKeyAuth
+// @Success 200 {file} file
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Router /admin/users/export [get]
+func (h *Handler) ExportUsers(c echo.Context) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="users.ndjson"`)
+	res.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the stream short;
+	// the error is still returned for the request log
+	return h.service.ExportUsers(c.Request().Context(), res)
+}
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"template/internal/config"
+	"template/internal/jwt"
+)
+
+var resetLinkToken = regexp.MustCompile(`reset-password\?token=([^"]+)`)
+
+func TestImportInviteOnlySetsFirstPassword(t *testing.T) {
+	imported := &User{ID: "imported", Email: "imported@example.com", Username: "imported", Role: RoleUser}
+	repo := newFakeRepo(imported)
+	mailer := &fakeMailer{}
+	s := newTestService(repo, mailer, config.AuthConfig{ImportInviteTokenTTL: time.Hour})
+
+	sent, err := s.InviteImportedUsers(context.Background(), []ImportedUser{{ID: imported.ID, Email: imported.Email}})
+	if err != nil || sent != 1 {
+		t.Fatalf("InviteImportedUsers() = %d, %v; want 1, nil", sent, err)
+	}
+	token := resetLinkToken.FindStringSubmatch(mailer.sent[0].body)[1]
+
+	claims, err := jwt.ValidateToken(token, s.jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != jwt.SubjectImportInvite || time.Until(claims.ExpiresAt.Time) > time.Hour {
+		t.Errorf("invite token subject %q expiring %v; want %q within an hour", claims.Subject, claims.ExpiresAt, jwt.SubjectImportInvite)
+	}
+	if _, accessErr := jwt.ValidateAccessToken(token, s.jwtSecret); accessErr == nil {
+		t.Error("invite token is accepted as an access token")
+	}
+
+	err = s.ResetPassword(context.Background(), token, "first-password")
+	if err != nil || imported.PasswordHash == "" {
+		t.Fatalf("ResetPassword() error = %v; want the password set", err)
+	}
+	err = s.ResetPassword(context.Background(), token, "second-password")
+	if err != ErrInvalidToken {
+		t.Errorf("reusing the invite error = %v; want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestInviteImportedUsersReportsFailures(t *testing.T) {
+	mailer := &fakeMailer{failTo: map[string]bool{"b@example.com": true}}
+	s := newTestService(newFakeRepo(), mailer, config.AuthConfig{ImportInviteTokenTTL: time.Hour})
+
+	users := []ImportedUser{{ID: "a", Email: "a@example.com"}, {ID: "b", Email: "b@example.com"}, {ID: "c", Email: "c@example.com"}}
+	sent, err := s.InviteImportedUsers(context.Background(), users)
+	if sent != 2 || err == nil {
+		t.Fatalf("InviteImportedUsers() = %d, %v; want 2 and an error for b@example.com", sent, err)
+	}
+}
+
+func TestQueueImportInvitesIsBounded(t *testing.T) {
+	mailer := &fakeMailer{}
+	s := newTestService(newFakeRepo(), mailer, config.AuthConfig{ImportInviteTokenTTL: time.Hour})
+	batch := []ImportedUser{{ID: "a", Email: "a@example.com"}}
+
+	for i := 0; i < inviteQueueSize; i++ {
+		if !s.QueueImportInvites(batch) {
+			t.Fatalf("QueueImportInvites() = false after %d batches; want room for %d", i, inviteQueueSize)
+		}
+	}
+	if s.QueueImportInvites(batch) {
+		t.Fatal("QueueImportInvites() = true on a full queue")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.RunInviteWorker(ctx)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); len(s.inviteQueue) > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(s.inviteQueue) != 0 || len(mailer.sent) < inviteQueueSize-1 {
+		t.Errorf("queue has %d batches left and %d emails were sent; want it drained", len(s.inviteQueue), len(mailer.sent))
+	}
+}
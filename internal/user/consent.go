@@ -0,0 +1,225 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Legal document types.
+const (
+	DocumentTypeTerms   = "terms"
+	DocumentTypePrivacy = "privacy"
+)
+
+var (
+	ErrConsentRequired       = errors.New("consent to current legal documents required")
+	ErrUnknownDocument       = errors.New("unknown legal document")
+	ErrDocumentVersionExists = errors.New("legal document version already exists")
+)
+
+type LegalDocument struct {
+	ID          string    `db:"id" json:"id"`
+	Type        string    `db:"type" json:"type"`
+	Version     string    `db:"version" json:"version"`
+	URL         string    `db:"url" json:"url"`
+	Mandatory   bool      `db:"mandatory" json:"mandatory"`
+	PublishedAt time.Time `db:"published_at" json:"published_at"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// Consent records that a user accepted a specific version of a legal document.
+type Consent struct {
+	ID          string    `db:"id" json:"id"`
+	UserID      string    `db:"user_id" json:"-"`
+	DocumentID  string    `db:"document_id" json:"document_id"`
+	Type        string    `db:"type" json:"type"`
+	Version     string    `db:"version" json:"version"`
+	PublishedAt time.Time `db:"published_at" json:"-"`
+	IP          string    `db:"ip" json:"ip"`
+	UserAgent   string    `db:"user_agent" json:"user_agent"`
+	AcceptedAt  time.Time `db:"accepted_at" json:"accepted_at"`
+}
+
+type ConsentStatus struct {
+	Accepted []Consent `json:"accepted"`
+	// Pending are mandatory documents the user still has to accept.
+	Pending []LegalDocument `json:"pending"`
+}
+
+type AcceptConsentsRequest struct {
+	DocumentIDs []string `json:"document_ids" validate:"required,min=1,dive,uuid"`
+}
+
+type PublishDocumentRequest struct {
+	Type    string `json:"type" validate:"required,oneof=terms privacy"`
+	Version string `json:"version" validate:"required,max=30"`
+	URL     string `json:"url" validate:"required,url"`
+	// Mandatory documents block API access until accepted. Defaults to true.
+	Mandatory *bool `json:"mandatory"`
+	// PublishedAt schedules the document; it defaults to now.
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+func (s *service) PublishLegalDocument(ctx context.Context, req *PublishDocumentRequest) (*LegalDocument, error) {
+	existing, err := s.repo.GetLegalDocument(ctx, req.Type, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrDocumentVersionExists
+	}
+
+	doc := &LegalDocument{
+		Type:        req.Type,
+		Version:     req.Version,
+		URL:         req.URL,
+		Mandatory:   true,
+		PublishedAt: time.Now(),
+	}
+	if req.Mandatory != nil {
+		doc.Mandatory = *req.Mandatory
+	}
+	if req.PublishedAt != nil {
+		doc.PublishedAt = *req.PublishedAt
+	}
+
+	err = s.repo.CreateLegalDocument(ctx, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+func (s *service) GetConsentStatus(ctx context.Context, userID string) (*ConsentStatus, error) {
+	accepted, err := s.repo.ListConsents(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := s.pendingConsents(ctx, accepted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsentStatus{Accepted: accepted, Pending: pending}, nil
+}
+
+// HasPendingConsents reports whether a newer mandatory document exists that the
+// user has not accepted yet.
+func (s *service) HasPendingConsents(ctx context.Context, userID string) (bool, error) {
+	accepted, err := s.repo.ListConsents(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	pending, err := s.pendingConsents(ctx, accepted)
+	if err != nil {
+		return false, err
+	}
+
+	return len(pending) > 0, nil
+}
+
+// AcceptConsents records the user's acceptance of the given published documents.
+// Accepting the same document twice is a no-op.
+func (s *service) AcceptConsents(ctx context.Context, userID string, req *AcceptConsentsRequest, client ClientInfo) error {
+	docs, err := s.publishedDocuments(ctx, req.DocumentIDs)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.CreateConsents(ctx, newConsents(userID, docs, client))
+}
+
+// checkRegistrationConsents makes sure a new user accepts every current
+// mandatory document and returns the accepted documents.
+func (s *service) checkRegistrationConsents(ctx context.Context, documentIDs []string) ([]LegalDocument, error) {
+	docs, err := s.publishedDocuments(ctx, documentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := make([]Consent, 0, len(docs))
+	for _, doc := range docs {
+		accepted = append(accepted, Consent{Type: doc.Type, PublishedAt: doc.PublishedAt})
+	}
+
+	pending, err := s.pendingConsents(ctx, accepted)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		return nil, ErrConsentRequired
+	}
+
+	return docs, nil
+}
+
+// pendingConsents returns the latest mandatory document of each type unless the
+// user accepted it or a newer version of the same type.
+func (s *service) pendingConsents(ctx context.Context, accepted []Consent) ([]LegalDocument, error) {
+	required, err := s.repo.ListRequiredLegalDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	latestAccepted := make(map[string]time.Time)
+	for _, consent := range accepted {
+		if consent.PublishedAt.After(latestAccepted[consent.Type]) {
+			latestAccepted[consent.Type] = consent.PublishedAt
+		}
+	}
+
+	pending := []LegalDocument{}
+	for _, doc := range required {
+		if latestAccepted[doc.Type].Before(doc.PublishedAt) {
+			pending = append(pending, doc)
+		}
+	}
+
+	return pending, nil
+}
+
+// publishedDocuments loads documents by ID, failing if any is unknown or not yet
+// published.
+func (s *service) publishedDocuments(ctx context.Context, ids []string) ([]LegalDocument, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	docs, err := s.repo.GetLegalDocuments(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	found := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		if doc.PublishedAt.After(now) {
+			return nil, ErrUnknownDocument
+		}
+		found[doc.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			return nil, ErrUnknownDocument
+		}
+	}
+
+	return docs, nil
+}
+
+func newConsents(userID string, docs []LegalDocument, client ClientInfo) []Consent {
+	consents := make([]Consent, 0, len(docs))
+	for _, doc := range docs {
+		consents = append(consents, Consent{
+			UserID:     userID,
+			DocumentID: doc.ID,
+			IP:         client.IP,
+			UserAgent:  client.UserAgent,
+		})
+	}
+	return consents
+}
@@ -0,0 +1,150 @@
+package user
+
+import (
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/jwt"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RegisterPublicRoutes registers routes that need no authentication. The
+// profile middleware, such as a dedicated rate limit, only applies to public
+// profile lookups.
+func (h *Handler) RegisterPublicRoutes(g *echo.Group, profileMiddleware ...echo.MiddlewareFunc) {
+	g.GET("/legal-documents", h.LegalDocuments)
+	g.GET("/users/:username", h.PublicProfile, profileMiddleware...)
+}
+
+// RegisterConsentRoutes registers the consent routes. They must stay reachable
+// for users blocked by RequireConsent.
+func (h *Handler) RegisterConsentRoutes(g *echo.Group) {
+	g.GET("/users/me/consents", h.Consents)
+	g.POST("/users/me/consents", h.AcceptConsents)
+}
+
+// LegalDocuments godoc
+// @Summary List current legal documents
+// @Description List the latest published version of each legal document, to show and accept at registration
+// @Tags legal
+// @Accept json
+// @Produce json
+// @Success 200 {object} response.Response{data=[]user.LegalDocument}
+// @Failure 500 {object} response.Response
+// @Router /legal-documents [get]
+func (h *Handler) LegalDocuments(c echo.Context) error {
+	docs, err := h.repo.ListCurrentLegalDocuments(c.Request().Context())
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, docs, nil)
+}
+
+// Consents godoc
+// @Summary Get consents
+// @Description List the legal documents the current user accepted and the mandatory ones still pending
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} response.Response{data=user.ConsentStatus}
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/consents [get]
+func (h *Handler) Consents(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	status, err := h.service.GetConsentStatus(c.Request().Context(), claims.UserID)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, status, nil)
+}
+
+// AcceptConsents godoc
+// @Summary Accept legal documents
+// @Description Record the current user's acceptance of the given legal document versions
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.AcceptConsentsRequest true "Accept Consents Request"
+// @Success 200 {object} response.Response{data=user.ConsentStatus}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/consents [post]
+func (h *Handler) AcceptConsents(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var req AcceptConsentsRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	client := ClientInfo{IP: c.RealIP(), UserAgent: c.Request().UserAgent()}
+	err := h.service.AcceptConsents(c.Request().Context(), claims.UserID, &req, client)
+	if err != nil {
+		if err == ErrUnknownDocument {
+			return response.ErrorJSON(c, http.StatusBadRequest, "UNKNOWN_DOCUMENT", "One or more documents do not exist or are not published", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	status, err := h.service.GetConsentStatus(c.Request().Context(), claims.UserID)
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, status, nil)
+}
+
+// PublishLegalDocument godoc
+// @Summary Publish a legal document version
+// @Description Publish a new version of the terms or privacy policy. Users must accept new mandatory versions before using the API again.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.PublishDocumentRequest true "Publish Document Request"
+// @Success 201 {object} response.Response{data=user.LegalDocument}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /admin/legal-documents [post]
+func (h *Handler) PublishLegalDocument(c echo.Context) error {
+	var req PublishDocumentRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	doc, err := h.service.PublishLegalDocument(c.Request().Context(), &req)
+	if err != nil {
+		if err == ErrDocumentVersionExists {
+			return response.ErrorJSON(c, http.StatusConflict, "DOCUMENT_VERSION_EXISTS", "This document version already exists", nil)
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusCreated, doc, nil)
+}
@@ -0,0 +1,113 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"template/internal/jwt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrAccountPendingDeletion = errors.New("account is scheduled for deletion")
+
+type DeleteAccountRequest struct {
+	// Password re-authenticates the user. Guests have none and may omit it.
+	Password string `json:"password"`
+}
+
+// DeleteAccount schedules the account for deletion after the grace period,
+// signs out every session and emails a link to cancel. Guests cannot be
+// reached by email, so they are scheduled for the next purge.
+func (s *service) DeleteAccount(ctx context.Context, userID string, req *DeleteAccountRequest) (*time.Time, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	deleteAt := time.Now()
+	if !user.IsGuest {
+		err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+		if err != nil {
+			return nil, ErrInvalidCredentials
+		}
+		deleteAt = deleteAt.Add(s.authConfig.Deletion.GracePeriod)
+	}
+
+	err = s.repo.ScheduleDeletion(ctx, user.ID, deleteAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repo.RevokeAllUserTokens(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsGuest {
+		err = s.sendDeletionScheduledEmail(user, deleteAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &deleteAt, nil
+}
+
+// sendDeletionScheduledEmail emails a link to cancel the deletion. The link only
+// works for Deletion.CancelTokenTTL, so a leaked email is not good for the
+// whole grace period; logging in with the password sends a fresh one.
+func (s *service) sendDeletionScheduledEmail(user *User, deleteAt time.Time) error {
+	ttl := min(s.authConfig.Deletion.CancelTokenTTL, time.Until(deleteAt))
+	token, err := jwt.GenerateCancelDeletionToken(user.ID, deleteAt, s.jwtSecret, ttl)
+	if err != nil {
+		return err
+	}
+
+	cancelLink := fmt.Sprintf("%s/cancel-deletion?token=%s", s.frontendHost, token)
+	body := fmt.Sprintf("Your account is scheduled to be permanently deleted on %s. "+
+		"All your sessions have been signed out.<br><br>"+
+		"Changed your mind? <a href=\"%s\">Click here</a> to keep your account.",
+		deleteAt.UTC().Format(time.RFC1123), cancelLink)
+
+	return s.emailSender.Send(user.Email, "Account Deletion Scheduled", body)
+}
+
+// CancelDeletion restores an account scheduled for deletion using the emailed
+// token and returns it. The user can log in again afterwards. A token only
+// cancels the deletion it was sent for, not one scheduled again later.
+func (s *service) CancelDeletion(ctx context.Context, token string) (*User, error) {
+	claims, err := jwt.ValidateToken(token, s.jwtSecret)
+	if err != nil || claims.Subject != jwt.SubjectCancelDeletion || claims.DeletionAt == nil {
+		return nil, ErrInvalidToken
+	}
+
+	cancelled, err := s.repo.CancelDeletion(ctx, claims.UserID, claims.DeletionAt.Time)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, ErrInvalidToken
+	}
+
+	return s.getUser(ctx, claims.UserID)
+}
+
+// PurgeDeletedAccounts permanently deletes accounts whose grace period is over.
+func (s *service) PurgeDeletedAccounts(ctx context.Context) (int64, error) {
+	n, avatarKeys, err := s.repo.PurgeDeletedUsers(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range avatarKeys {
+		s.deleteAvatar(ctx, key)
+	}
+
+	return n, nil
+}
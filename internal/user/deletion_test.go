@@ -0,0 +1,85 @@
+package user
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"template/internal/config"
+	"template/internal/jwt"
+)
+
+var cancelLinkToken = regexp.MustCompile(`cancel-deletion\?token=([^"]+)`)
+
+func TestCancelDeletionTokenIsShortLivedAndSinglePurpose(t *testing.T) {
+	u := existingUser(t)
+	repo := newFakeRepo(u)
+	mailer := &fakeMailer{}
+	s := newTestService(repo, mailer, config.AuthConfig{
+		Deletion: config.DeletionConfig{GracePeriod: 30 * 24 * time.Hour, CancelTokenTTL: time.Hour},
+	})
+
+	_, err := s.DeleteAccount(context.Background(), u.ID, &DeleteAccountRequest{Password: "correct-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("sent %d emails; want 1", len(mailer.sent))
+	}
+	match := cancelLinkToken.FindStringSubmatch(mailer.sent[0].body)
+	if match == nil {
+		t.Fatalf("no cancel link in %q", mailer.sent[0].body)
+	}
+	token := match[1]
+
+	claims, err := jwt.ValidateToken(token, s.jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > time.Hour {
+		t.Errorf("cancel token expires in %v; want at most %v", ttl, time.Hour)
+	}
+	if _, accessErr := jwt.ValidateAccessToken(token, s.jwtSecret); accessErr == nil {
+		t.Error("cancel token is accepted as an access token")
+	}
+
+	reset, err := jwt.GenerateResetToken(u.ID, s.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.CancelDeletion(context.Background(), reset); err != ErrInvalidToken {
+		t.Errorf("CancelDeletion(reset token) error = %v; want %v", err, ErrInvalidToken)
+	}
+
+	_, err = s.CancelDeletion(context.Background(), token)
+	if err != nil || u.DeletionScheduledAt != nil {
+		t.Fatalf("CancelDeletion() error = %v, scheduled at %v; want cancelled", err, u.DeletionScheduledAt)
+	}
+
+	// The link is spent once a new deletion is scheduled
+	later := time.Now().Add(40 * 24 * time.Hour)
+	u.DeletionScheduledAt = &later
+	_, err = s.CancelDeletion(context.Background(), token)
+	if err != ErrInvalidToken || u.DeletionScheduledAt == nil {
+		t.Errorf("CancelDeletion() of a later deletion error = %v, scheduled at %v; want %v", err, u.DeletionScheduledAt, ErrInvalidToken)
+	}
+}
+
+func TestLoginResendsCancelLinkDuringGracePeriod(t *testing.T) {
+	u := existingUser(t)
+	deleteAt := time.Now().Add(10 * 24 * time.Hour)
+	u.DeletionScheduledAt = &deleteAt
+	mailer := &fakeMailer{}
+	s := newTestService(newFakeRepo(u), mailer, config.AuthConfig{
+		Deletion: config.DeletionConfig{CancelTokenTTL: time.Hour},
+	})
+
+	_, err := s.Login(context.Background(), &LoginRequest{Email: u.Email, Password: "correct-password"}, ClientInfo{})
+	if err != ErrAccountPendingDeletion {
+		t.Fatalf("Login() error = %v; want %v", err, ErrAccountPendingDeletion)
+	}
+	if len(mailer.sent) != 1 || !cancelLinkToken.MatchString(mailer.sent[0].body) {
+		t.Errorf("sent %+v; want a cancel link", mailer.sent)
+	}
+}
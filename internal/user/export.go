@@ -0,0 +1,62 @@
+package user
+
+import (
+	"context"
+
+	"template/internal/export"
+)
+
+// exportPageSize is how many rows are read at a time for unbounded sections.
+const exportPageSize = 500
+
+// avatarExport describes the user's avatar in an export. It is empty when
+// they have none.
+type avatarExport struct {
+	Key        string            `json:"key,omitempty"`
+	URL        string            `json:"url,omitempty"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+}
+
+// RegisterExportSections adds the data held by the user module to personal data
+// exports.
+func RegisterExportSections(registry *export.Registry, repo Repository, service Service) {
+	registry.Register("profile", func(ctx context.Context, userID string) (interface{}, error) {
+		return repo.GetByID(ctx, userID)
+	})
+
+	registry.Register("sessions", func(ctx context.Context, userID string) (interface{}, error) {
+		return repo.ListSessions(ctx, userID)
+	})
+
+	registry.Register("login_history", func(ctx context.Context, userID string) (interface{}, error) {
+		events := []LoginEvent{}
+		for offset := 0; ; offset += exportPageSize {
+			page, total, err := repo.ListLoginEvents(ctx, userID, exportPageSize, offset)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, page...)
+			if len(page) == 0 || offset+len(page) >= total {
+				return events, nil
+			}
+		}
+	})
+
+	registry.Register("consents", func(ctx context.Context, userID string) (interface{}, error) {
+		return repo.ListConsents(ctx, userID)
+	})
+
+	registry.Register("avatar", func(ctx context.Context, userID string) (interface{}, error) {
+		user, err := repo.GetByID(ctx, userID)
+		if err != nil || user == nil {
+			return nil, err
+		}
+
+		url, thumbnails, err := service.AvatarURLs(ctx, user.AvatarKey)
+		if err != nil {
+			return nil, err
+		}
+
+		return avatarExport{Key: user.AvatarKey, URL: url, Thumbnails: thumbnails}, nil
+	})
+}
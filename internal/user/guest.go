@@ -0,0 +1,164 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"template/internal/jwt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrGuestsDisabled = errors.New("guest accounts are disabled")
+	ErrNotGuest       = errors.New("user is not a guest")
+)
+
+// CreateGuest creates an anonymous account with no email or password and starts
+// a session for it.
+func (s *service) CreateGuest(ctx context.Context, client ClientInfo) (*jwt.TokenPair, error) {
+	if !s.authConfig.Guest.Enabled {
+		return nil, ErrGuestsDisabled
+	}
+
+	username, err := generateGuestUsername()
+	if err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Username: username,
+		Role:     RoleUser,
+	}
+
+	err = s.repo.CreateGuest(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.generateTokens(ctx, user, sessionState{}, client)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordLogin(ctx, user.ID, LoginMethodGuest, true, client)
+	return tokens, nil
+}
+
+// UpgradeGuest attaches an email and password to a guest account. The user ID
+// stays the same, so everything the guest created is preserved, and existing
+// sessions remain valid. The same registration policy as Register applies.
+//
+// Only email and password credentials are supported: the service has no
+// external identity providers, so there is nothing to link a guest to yet.
+//
+// It reports whether the account was upgraded. With enumeration protection
+// enabled it reports false and a nil error for taken emails and usernames as
+// well as for successful upgrades; the outcome is only communicated by email.
+func (s *service) UpgradeGuest(ctx context.Context, userID string, req *UpgradeRequest) (bool, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if user == nil {
+		return false, ErrUserNotFound
+	}
+	if !user.IsGuest {
+		return false, ErrNotGuest
+	}
+
+	req.Email = normalizeEmail(req.Email)
+	username := user.Username
+	if req.Username != "" {
+		username = normalizeUsername(req.Username)
+	}
+
+	err = s.checkEmailDomain(req.Email)
+	if err != nil {
+		return false, err
+	}
+
+	if username != user.Username {
+		err = s.checkReservedUsername(username)
+		if err != nil {
+			return false, err
+		}
+
+		err = validateUsername(username)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = s.validateInvite(ctx, req.InviteCode)
+	if err != nil {
+		return false, err
+	}
+
+	existingUser, err := s.repo.GetByEmail(ctx, req.Email)
+	if err != nil {
+		return false, err
+	}
+
+	// Hash before branching so every path spends the same time in bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return false, err
+	}
+
+	if existingUser != nil {
+		if !s.authConfig.EnumerationProtection {
+			return false, ErrUserAlreadyExists
+		}
+		return false, s.sendAccountExistsEmail(existingUser)
+	}
+
+	if username != user.Username {
+		err = s.checkUsernameAvailable(ctx, user.ID, username)
+		if err != nil {
+			if s.authConfig.EnumerationProtection && usernameUnavailable(err) {
+				return false, s.sendUsernameUnavailableEmail(req.Email, username)
+			}
+			return false, err
+		}
+	}
+
+	err = s.consumeInvite(ctx, req.InviteCode)
+	if err != nil {
+		return false, err
+	}
+
+	upgraded, err := s.repo.UpgradeGuest(ctx, user.ID, req.Email, username, string(hashedPassword))
+	if err != nil || !upgraded {
+		s.releaseInvite(ctx, req.InviteCode)
+		if err != nil {
+			return false, err
+		}
+		return false, ErrNotGuest
+	}
+
+	user.Email = req.Email
+	err = s.sendWelcomeEmail(user)
+	if err != nil {
+		return false, err
+	}
+
+	return !s.authConfig.EnumerationProtection, nil
+}
+
+// CleanupGuests deletes guests that have been inactive for longer than the
+// configured TTL. Refreshing a session counts as activity.
+func (s *service) CleanupGuests(ctx context.Context) (int64, error) {
+	return s.repo.DeleteInactiveGuests(ctx, time.Now().Add(-s.authConfig.Guest.InactiveTTL))
+}
+
+func generateGuestUsername() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "guest_" + hex.EncodeToString(b), nil
+}
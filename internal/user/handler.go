@@ -6,22 +6,42 @@ import (
 	"template/internal/json"
 	"template/internal/jwt"
 	"template/internal/response"
+	"template/internal/validator"
 
 	"github.com/labstack/echo/v4"
 )
 
 type Handler struct {
-	repo Repository
+	repo      Repository
+	service   Service
+	validator *validator.Validator
+	profiles  *ProfileCache
 }
 
-func NewHandler(repo Repository) *Handler {
+func NewHandler(repo Repository, service Service, validator *validator.Validator, profiles *ProfileCache) *Handler {
 	return &Handler{
-		repo: repo,
+		repo:      repo,
+		service:   service,
+		validator: validator,
+		profiles:  profiles,
 	}
 }
 
 func (h *Handler) RegisterRoutes(g *echo.Group) {
 	g.GET("/users/me", h.Me)
+	g.PATCH("/users/me", h.UpdateProfile)
+	g.PUT("/users/me/avatar", h.UpdateAvatar)
+	g.DELETE("/users/me/avatar", h.RemoveAvatar)
+	g.POST("/users/me/password", h.ChangePassword)
+	g.POST("/users/me/email", h.ChangeEmail)
+	g.POST("/users/me/upgrade", h.Upgrade)
+	g.GET("/users/me/login-history", h.LoginHistory)
+}
+
+// RegisterAccountRoutes registers routes that stay reachable for users blocked
+// by RequireConsent, so they can always leave.
+func (h *Handler) RegisterAccountRoutes(g *echo.Group) {
+	g.DELETE("/users/me", h.DeleteAccount)
 }
 
 // Me godoc
@@ -49,5 +69,322 @@ func (h *Handler) Me(c echo.Context) error {
 		return json.NotFound(c, "User not found")
 	}
 
+	if err := h.withAvatarURLs(c.Request().Context(), user); err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, user, nil)
+}
+
+// UpdateProfile godoc
+// @Summary Update profile
+// @Description Update the current user's profile with JSON merge patch semantics: omitted fields are unchanged and null clears a field
+// @Tags users
+// @Accept json,application/merge-patch+json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.UpdateProfileRequest true "Profile Patch"
+// @Success 200 {object} response.Response{data=user.User}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me [patch]
+func (h *Handler) UpdateProfile(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	// Decoded directly since Bind rejects application/merge-patch+json
+	var req UpdateProfileRequest
+	if err := c.Echo().JSONSerializer.Deserialize(c, &req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	user, err := h.service.UpdateProfile(c.Request().Context(), claims.UserID, &req)
+	if err != nil {
+		if err == ErrUsernameTaken {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_TAKEN", "Username is already taken", nil)
+		}
+		if err == ErrUsernameConfusable {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_CONFUSABLE", "Username is too similar to an existing username", nil)
+		}
+		if err == ErrUsernameReserved {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_RESERVED", "Username is reserved", nil)
+		}
+		if err == ErrInvalidUsername {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_USERNAME", "Username must be 3 to 50 letters of one script, digits, '_', '-' or '.'", nil)
+		}
+		if err == ErrUserNotFound {
+			return json.NotFound(c, "User not found")
+		}
+		return json.InternalServerError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), user.ID, user.Username)
+
+	if err := h.withAvatarURLs(c.Request().Context(), user); err != nil {
+		return json.InternalServerError(c, err)
+	}
+
 	return response.JSON(c, http.StatusOK, user, nil)
 }
+
+// ChangePassword godoc
+// @Summary Change password
+// @Description Change the current user's password and sign out all other sessions
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.ChangePasswordRequest true "Change Password Request"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/password [post]
+func (h *Handler) ChangePassword(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var req ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	err := h.service.ChangePassword(c.Request().Context(), claims.UserID, claims.SessionID, &req)
+	if err != nil {
+		if err == ErrInvalidCredentials {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_CURRENT_PASSWORD", "Current password is incorrect", nil)
+		}
+		if err == ErrPasswordReused {
+			return response.ErrorJSON(c, http.StatusBadRequest, "PASSWORD_REUSED", "New password must not match a recently used password", nil)
+		}
+		if err == ErrUserNotFound {
+			return json.NotFound(c, "User not found")
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Password updated successfully"}, nil)
+}
+
+// ChangeEmail godoc
+// @Summary Change email
+// @Description Send a confirmation link to the new address; the email is only changed once confirmed
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.ChangeEmailRequest true "Change Email Request"
+// @Success 202 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/email [post]
+func (h *Handler) ChangeEmail(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var req ChangeEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	err := h.service.RequestEmailChange(c.Request().Context(), claims.UserID, &req)
+	if err != nil {
+		if err == ErrInvalidCredentials {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_CURRENT_PASSWORD", "Current password is incorrect", nil)
+		}
+		if err == ErrUserAlreadyExists {
+			return response.ErrorJSON(c, http.StatusConflict, "USER_ALREADY_EXISTS", "User with this email already exists", nil)
+		}
+		if err == ErrEmailDomainNotAllowed {
+			return response.ErrorJSON(c, http.StatusForbidden, "EMAIL_DOMAIN_NOT_ALLOWED", "This email domain is not allowed", nil)
+		}
+		if err == ErrDisposableEmail {
+			return response.ErrorJSON(c, http.StatusForbidden, "DISPOSABLE_EMAIL_NOT_ALLOWED", "Disposable email addresses are not allowed", nil)
+		}
+		if err == ErrUserNotFound {
+			return json.NotFound(c, "User not found")
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusAccepted, map[string]string{"message": "A confirmation link has been sent to the new email address."}, nil)
+}
+
+// Upgrade godoc
+// @Summary Upgrade a guest account
+// @Description Attach an email and password to the current guest account, keeping its ID and data
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.UpgradeRequest true "Upgrade Request"
+// @Success 200 {object} response.Response
+// @Success 202 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 409 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/upgrade [post]
+func (h *Handler) Upgrade(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var req UpgradeRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	upgraded, err := h.service.UpgradeGuest(c.Request().Context(), claims.UserID, &req)
+	if err != nil {
+		if err == ErrNotGuest {
+			return response.ErrorJSON(c, http.StatusConflict, "NOT_A_GUEST", "Account is already a full account", nil)
+		}
+		if err == ErrUserAlreadyExists {
+			return response.ErrorJSON(c, http.StatusConflict, "USER_ALREADY_EXISTS", "User with this email already exists", nil)
+		}
+		if err == ErrUsernameTaken {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_TAKEN", "Username is already taken", nil)
+		}
+		if err == ErrUsernameConfusable {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_CONFUSABLE", "Username is too similar to an existing username", nil)
+		}
+		if err == ErrUsernameReserved {
+			return response.ErrorJSON(c, http.StatusConflict, "USERNAME_RESERVED", "Username is reserved", nil)
+		}
+		if err == ErrInvalidUsername {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_USERNAME", "Username must be 3 to 50 letters of one script, digits, '_', '-' or '.'", nil)
+		}
+		if err == ErrInviteRequired {
+			return response.ErrorJSON(c, http.StatusForbidden, "INVITE_REQUIRED", "Registration requires an invite code", nil)
+		}
+		if err == ErrInvalidInvite {
+			return response.ErrorJSON(c, http.StatusForbidden, "INVALID_INVITE", "Invite code is invalid, expired or used up", nil)
+		}
+		if err == ErrEmailDomainNotAllowed {
+			return response.ErrorJSON(c, http.StatusForbidden, "EMAIL_DOMAIN_NOT_ALLOWED", "Registration is not open to this email domain", nil)
+		}
+		if err == ErrDisposableEmail {
+			return response.ErrorJSON(c, http.StatusForbidden, "DISPOSABLE_EMAIL_NOT_ALLOWED", "Disposable email addresses are not allowed", nil)
+		}
+		if err == ErrUserNotFound {
+			return json.NotFound(c, "User not found")
+		}
+		return json.InternalServerError(c, err)
+	}
+
+	// Enumeration protection: same response whether or not the email was taken
+	if !upgraded {
+		return response.JSON(c, http.StatusAccepted, map[string]string{"message": "Check your email to continue."}, nil)
+	}
+
+	return response.JSON(c, http.StatusOK, map[string]string{"message": "Account upgraded successfully"}, nil)
+}
+
+// DeleteAccount godoc
+// @Summary Delete account
+// @Description Schedule the current account for deletion after a grace period and sign out all sessions. A cancellation link is sent by email.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body user.DeleteAccountRequest true "Delete Account Request"
+// @Success 202 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me [delete]
+func (h *Handler) DeleteAccount(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var req DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	deleteAt, err := h.service.DeleteAccount(c.Request().Context(), claims.UserID, &req)
+	if err != nil {
+		if err == ErrInvalidCredentials {
+			return response.ErrorJSON(c, http.StatusBadRequest, "INVALID_CURRENT_PASSWORD", "Current password is incorrect", nil)
+		}
+		if err == ErrUserNotFound {
+			return json.NotFound(c, "User not found")
+		}
+		return json.InternalServerError(c, err)
+	}
+	h.profiles.Invalidate(c.Request().Context(), claims.UserID, "")
+
+	return response.JSON(c, http.StatusAccepted, map[string]interface{}{
+		"message":               "Your account is scheduled for deletion. Check your email to cancel.",
+		"deletion_scheduled_at": deleteAt,
+	}, nil)
+}
+
+// LoginHistory godoc
+// @Summary Get login history
+// @Description List the current user's login attempts, newest first
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param page query int false "Page number" minimum(1)
+// @Param per_page query int false "Items per page" minimum(1) maximum(100)
+// @Success 200 {object} response.Response{data=[]user.LoginEvent,meta=response.PageMeta}
+// @Failure 400 {object} response.Response
+// @Failure 401 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/me/login-history [get]
+func (h *Handler) LoginHistory(c echo.Context) error {
+	claims, ok := c.Get("user").(*jwt.Claims)
+	if !ok {
+		return json.Unauthorized(c, "Invalid token")
+	}
+
+	var q PageQuery
+	if err := c.Bind(&q); err != nil {
+		return json.BadRequest(c, err)
+	}
+
+	if err := h.validator.Validate(q); err != nil {
+		return json.BadRequest(c, err)
+	}
+	q.Normalize()
+
+	events, total, err := h.repo.ListLoginEvents(c.Request().Context(), claims.UserID, q.PerPage, q.Offset())
+	if err != nil {
+		return json.InternalServerError(c, err)
+	}
+
+	return response.JSON(c, http.StatusOK, events, response.PageMeta{Page: q.Page, PerPage: q.PerPage, Total: total})
+}
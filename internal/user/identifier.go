@@ -0,0 +1,214 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrInvalidUsername    = errors.New("username must be 3 to 50 letters of one script, digits, '_', '-' or '.'")
+	ErrUsernameReserved   = errors.New("username is reserved")
+	ErrUsernameConfusable = errors.New("username is confusable with an existing username")
+)
+
+// reservedUsernames cannot be taken by users because they clash with routes,
+// such as /users/me, or could pass for the service itself. Deployments can
+// extend the list with REGISTRATION_RESERVED_USERNAMES.
+var reservedUsernames = map[string]struct{}{
+	"abuse":         {},
+	"account":       {},
+	"admin":         {},
+	"administrator": {},
+	"anonymous":     {},
+	"api":           {},
+	"auth":          {},
+	"guest":         {},
+	"help":          {},
+	"hostmaster":    {},
+	"login":         {},
+	"logout":        {},
+	"me":            {},
+	"moderator":     {},
+	"no-reply":      {},
+	"noreply":       {},
+	"null":          {},
+	"official":      {},
+	"postmaster":    {},
+	"register":      {},
+	"root":          {},
+	"security":      {},
+	"settings":      {},
+	"staff":         {},
+	"support":       {},
+	"system":        {},
+	"undefined":     {},
+	"user":          {},
+	"users":         {},
+	"webmaster":     {},
+}
+
+// compatibleScripts may appear together in a username, as Japanese names mix
+// Han, Hiragana and Katakana with Latin. Any other mix of scripts is rejected,
+// since it mostly serves to pass for someone else.
+var compatibleScripts = map[string]bool{
+	"Latin":    true,
+	"Han":      true,
+	"Hiragana": true,
+	"Katakana": true,
+	"Hangul":   true,
+	"Bopomofo": true,
+}
+
+// normalizeEmail returns the form emails are stored and looked up in, so that
+// addresses differing only in case or Unicode representation belong to the
+// same account. Emails are lowercased rather than case folded, because folding
+// rewrites characters such as "ß" and the result may no longer be delivered.
+func normalizeEmail(email string) string {
+	email = norm.NFKC.String(strings.TrimSpace(email))
+	return cases.Lower(language.Und).String(email)
+}
+
+// normalizeUsername returns the form usernames are stored and looked up in. It
+// applies NFKC_Casefold as in Unicode Standard Annex #31: folding can
+// denormalize text, so it is normalized again afterwards.
+func normalizeUsername(username string) string {
+	username = norm.NFKC.String(strings.TrimSpace(username))
+	return norm.NFKC.String(cases.Fold().String(username))
+}
+
+// validateUsername checks the characters of a normalized username. Letters
+// must all come from one script, or from compatibleScripts, so that a Cyrillic
+// "а" cannot stand in for a Latin "a".
+func validateUsername(username string) error {
+	n := utf8.RuneCountInString(username)
+	if n < 3 || n > 50 {
+		return ErrInvalidUsername
+	}
+
+	scripts := make(map[string]bool)
+	for _, r := range username {
+		switch {
+		case r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+			continue
+		case unicode.In(r, unicode.Mn, unicode.Mc):
+			// Combining marks belong to the preceding letter
+			continue
+		case !unicode.IsLetter(r):
+			return ErrInvalidUsername
+		}
+
+		script := scriptOf(r)
+		if script != "" {
+			scripts[script] = true
+		}
+	}
+
+	if len(scripts) > 1 {
+		for script := range scripts {
+			if !compatibleScripts[script] {
+				return ErrInvalidUsername
+			}
+		}
+	}
+
+	return nil
+}
+
+// scriptOf returns the Unicode script of r, or "" for characters shared
+// between scripts.
+func scriptOf(r rune) string {
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// checkReservedUsername rejects built-in and configured reserved usernames. It
+// only applies to users choosing their own username; admins may assign them.
+// Lookalikes of reserved usernames, such as "adm1n" or "no.reply", are
+// rejected too.
+func (s *service) checkReservedUsername(username string) error {
+	key := reservedKey(username)
+	if _, ok := reservedKeys[key]; ok {
+		return ErrUsernameReserved
+	}
+	for _, reserved := range s.authConfig.Registration.ReservedUsernames {
+		if reservedKey(normalizeUsername(reserved)) == key {
+			return ErrUsernameReserved
+		}
+	}
+	return nil
+}
+
+// skeletonReplacer maps lookalike characters to one of them, exactly as the
+// username_skeleton SQL function does. Keep the two in sync.
+var skeletonReplacer = func() *strings.Replacer {
+	from := []rune("аеорсухіјѕԁһԛԝӏαοινρυχγɑɡı01-.")
+	to := []rune("aeopcyxijsdhqwlaoivpuxyagiol__")
+	pairs := make([]string, 0, 2*len(from))
+	for i := range from {
+		pairs = append(pairs, string(from[i]), string(to[i]))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+// usernameSkeleton is the Go equivalent of the username_skeleton SQL
+// function: usernames with the same skeleton look alike.
+func usernameSkeleton(username string) string {
+	return skeletonReplacer.Replace(strings.ToLower(norm.NFKC.String(username)))
+}
+
+// reservedKey is the skeleton reserved usernames are compared by. It also
+// folds "i" into "l", since the skeleton turns "1" into "l" but "adm1n" passes
+// for "admin" as well.
+func reservedKey(username string) string {
+	return strings.ReplaceAll(usernameSkeleton(username), "i", "l")
+}
+
+// reservedKeys are the reservedKey of every reservedUsernames entry.
+var reservedKeys = func() map[string]struct{} {
+	keys := make(map[string]struct{}, len(reservedUsernames))
+	for username := range reservedUsernames {
+		keys[reservedKey(username)] = struct{}{}
+	}
+	return keys
+}()
+
+// checkUsernameAvailable validates a normalized username and makes sure no
+// live user other than userID has it or one that only differs by lookalike
+// characters. userID is empty for new users.
+func (s *service) checkUsernameAvailable(ctx context.Context, userID, username string) error {
+	err := validateUsername(username)
+	if err != nil {
+		return err
+	}
+
+	existing, err := s.repo.FindSimilarUsername(ctx, username, userID)
+	if err != nil {
+		return err
+	}
+
+	switch existing {
+	case "":
+		return nil
+	case username:
+		return ErrUsernameTaken
+	default:
+		return ErrUsernameConfusable
+	}
+}
+
+// usernameUnavailable reports whether err means another user holds the
+// username or a lookalike of it.
+func usernameUnavailable(err error) bool {
+	return err == ErrUsernameTaken || err == ErrUsernameConfusable
+}
@@ -0,0 +1,91 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+var ErrUsernameRequired = errors.New("username cannot be removed")
+
+// UpdateProfileRequest is a JSON merge patch (RFC 7396) of the profile: absent
+// fields are left unchanged and null clears a field. Username cannot be cleared.
+type UpdateProfileRequest struct {
+	Username    *string `json:"username" validate:"omitnil,min=3,max=50"`
+	DisplayName *string `json:"display_name" validate:"omitnil,max=100"`
+	Bio         *string `json:"bio" validate:"omitnil,max=500"`
+	Locale      *string `json:"locale" validate:"omitnil,eq=|bcp47_language_tag"`
+	Timezone    *string `json:"timezone" validate:"omitnil,eq=|timezone"`
+	// ProfileVisibility controls GET /users/{username}. Null resets it to public.
+	ProfileVisibility *string `json:"profile_visibility" validate:"omitnil,oneof=public private"`
+}
+
+// UnmarshalJSON turns explicit nulls into empty values so they clear the field.
+func (r *UpdateProfileRequest) UnmarshalJSON(data []byte) error {
+	type patch UpdateProfileRequest
+	if err := json.Unmarshal(data, (*patch)(r)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	for name, value := range fields {
+		if string(value) != "null" {
+			continue
+		}
+
+		empty := ""
+		switch name {
+		case "username":
+			return ErrUsernameRequired
+		case "display_name":
+			r.DisplayName = &empty
+		case "bio":
+			r.Bio = &empty
+		case "locale":
+			r.Locale = &empty
+		case "timezone":
+			r.Timezone = &empty
+		case "profile_visibility":
+			visibility := ProfileVisibilityPublic
+			r.ProfileVisibility = &visibility
+		}
+	}
+
+	return nil
+}
+
+// UpdateProfile applies a profile patch and returns the updated user.
+func (s *service) UpdateProfile(ctx context.Context, userID string, req *UpdateProfileRequest) (*User, error) {
+	user, err := s.getUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Username != nil {
+		username := normalizeUsername(*req.Username)
+		req.Username = &username
+	}
+
+	if req.Username != nil && *req.Username != user.Username {
+		err = s.checkReservedUsername(*req.Username)
+		if err != nil {
+			return nil, err
+		}
+
+		err = s.checkUsernameAvailable(ctx, user.ID, *req.Username)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.repo.UpdateProfile(ctx, user.ID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetByID(ctx, user.ID)
+}
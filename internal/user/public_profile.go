@@ -0,0 +1,95 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"template/internal/redis"
+)
+
+// Profile visibility settings.
+const (
+	ProfileVisibilityPublic  = "public"
+	ProfileVisibilityPrivate = "private"
+)
+
+// PublicProfile is the projection of User that anyone can look up by username.
+// It must never include contact details or credentials.
+type PublicProfile struct {
+	ID          string    `db:"id" json:"id"`
+	Username    string    `db:"username" json:"username"`
+	DisplayName string    `db:"display_name" json:"display_name"`
+	Bio         string    `db:"bio" json:"bio"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	AvatarKey   string    `db:"avatar_key" json:"-"`
+	// AvatarURL and AvatarThumbnails are resolved before the profile is cached.
+	AvatarURL        string            `db:"-" json:"avatar_url,omitempty"`
+	AvatarThumbnails map[string]string `db:"-" json:"avatar_thumbnails,omitempty"`
+}
+
+// ProfileCache caches public profile lookups in Redis, including misses, so
+// repeated lookups do not reach the database.
+type ProfileCache struct {
+	redis *redis.Client
+	ttl   time.Duration
+}
+
+func NewProfileCache(redisClient *redis.Client, ttl time.Duration) *ProfileCache {
+	return &ProfileCache{
+		redis: redisClient,
+		ttl:   ttl,
+	}
+}
+
+// Get returns the cached profile, which is nil for a cached miss. ok is false if
+// nothing is cached.
+func (c *ProfileCache) Get(ctx context.Context, username string) (*PublicProfile, bool) {
+	data, err := c.redis.Get(ctx, profileKey(username))
+	if err != nil {
+		return nil, false
+	}
+
+	var profile *PublicProfile
+	if err := json.Unmarshal([]byte(data), &profile); err != nil {
+		return nil, false
+	}
+
+	return profile, true
+}
+
+// Set caches a lookup result; profile is nil for a miss. The user ID is indexed
+// so Invalidate also finds entries cached under a previous username.
+func (c *ProfileCache) Set(ctx context.Context, username string, profile *PublicProfile) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return
+	}
+
+	_ = c.redis.Set(ctx, profileKey(username), data, c.ttl)
+	if profile != nil {
+		_ = c.redis.Set(ctx, profileOwnerKey(profile.ID), username, c.ttl)
+	}
+}
+
+// Invalidate drops the cached profile of a user after it changed, along with a
+// cached miss for their current username if it is known.
+func (c *ProfileCache) Invalidate(ctx context.Context, userID, username string) {
+	if username != "" {
+		_ = c.redis.Del(ctx, profileKey(username))
+	}
+
+	cached, err := c.redis.GetDel(ctx, profileOwnerKey(userID))
+	if err == nil {
+		_ = c.redis.Del(ctx, profileKey(cached))
+	}
+}
+
+func profileKey(username string) string {
+	return fmt.Sprintf("profile:username:%s", username)
+}
+
+func profileOwnerKey(userID string) string {
+	return fmt.Sprintf("profile:owner:%s", userID)
+}
@@ -0,0 +1,53 @@
+package user
+
+import (
+	"fmt"
+	"net/http"
+
+	"template/internal/json"
+	"template/internal/response"
+
+	"github.com/labstack/echo/v4"
+)
+
+// PublicProfile godoc
+// @Summary Get a public profile
+// @Description Look up another user's public profile by username. Users with a private profile are reported as not found.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param username path string true "Username"
+// @Success 200 {object} response.Response{data=user.PublicProfile}
+// @Failure 404 {object} response.Response
+// @Failure 429 {object} response.Response
+// @Failure 500 {object} response.Response
+// @Router /users/{username} [get]
+func (h *Handler) PublicProfile(c echo.Context) error {
+	ctx := c.Request().Context()
+	username := normalizeUsername(c.Param("username"))
+
+	profile, cached := h.profiles.Get(ctx, username)
+	if !cached {
+		var err error
+		profile, err = h.repo.GetPublicProfile(ctx, username)
+		if err != nil {
+			return json.InternalServerError(c, err)
+		}
+		if profile != nil {
+			profile.AvatarURL, profile.AvatarThumbnails, err = h.service.AvatarURLs(ctx, profile.AvatarKey)
+			if err != nil {
+				return json.InternalServerError(c, err)
+			}
+		}
+		h.profiles.Set(ctx, username, profile)
+	}
+
+	if profile == nil {
+		return json.NotFound(c, "User not found")
+	}
+
+	// Only the viewer's browser may keep it: shared caches could not be told
+	// when the profile turns private
+	c.Response().Header().Set(echo.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(h.profiles.ttl.Seconds())))
+	return response.JSON(c, http.StatusOK, profile, nil)
+}
@@ -0,0 +1,171 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"template/internal/config"
+)
+
+// disposableDomains is a built-in list of common throwaway email providers.
+// Deployments can extend it with REGISTRATION_BLOCKED_DOMAINS.
+var disposableDomains = map[string]struct{}{
+	"10minutemail.com":       {},
+	"dispostable.com":        {},
+	"fakeinbox.com":          {},
+	"getnada.com":            {},
+	"guerrillamail.com":      {},
+	"guerrillamail.net":      {},
+	"maildrop.cc":            {},
+	"mailinator.com":         {},
+	"mailnesia.com":          {},
+	"mintemail.com":          {},
+	"mohmal.com":             {},
+	"sharklasers.com":        {},
+	"spamgourmet.com":        {},
+	"temp-mail.org":          {},
+	"tempmail.com":           {},
+	"tempmailo.com":          {},
+	"throwawaymail.com":      {},
+	"trashmail.com":          {},
+	"yopmail.com":            {},
+	"emailondeck.com":        {},
+	"mailcatch.com":          {},
+	"discard.email":          {},
+	"burnermail.io":          {},
+	"guerrillamailblock.com": {},
+}
+
+type Invite struct {
+	ID        string     `db:"id" json:"id"`
+	Code      string     `db:"code" json:"code"`
+	MaxUses   int        `db:"max_uses" json:"max_uses"`
+	Uses      int        `db:"uses" json:"uses"`
+	ExpiresAt *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	Revoked   bool       `db:"revoked" json:"revoked"`
+	CreatedBy *string    `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+type CreateInviteRequest struct {
+	// MaxUses defaults to a single-use invite.
+	MaxUses   int        `json:"max_uses" validate:"omitempty,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// checkEmailDomain enforces the domain allowlist (in domain mode) and the
+// disposable domain blocklist.
+func (s *service) checkEmailDomain(email string) error {
+	policy := s.authConfig.Registration
+
+	_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+
+	if policy.BlockDisposable {
+		if _, ok := disposableDomains[domain]; ok {
+			return ErrDisposableEmail
+		}
+		for _, blocked := range policy.BlockedDomains {
+			if strings.EqualFold(domain, blocked) {
+				return ErrDisposableEmail
+			}
+		}
+	}
+
+	if policy.Mode == config.RegistrationDomain {
+		for _, allowed := range policy.AllowedDomains {
+			if strings.EqualFold(domain, allowed) {
+				return nil
+			}
+		}
+		return ErrEmailDomainNotAllowed
+	}
+
+	return nil
+}
+
+// validateInvite checks the invite in invite mode without using it, so an
+// invalid invite is rejected the same way whether or not the email is taken.
+// It is a no-op in other modes.
+func (s *service) validateInvite(ctx context.Context, code string) error {
+	if s.authConfig.Registration.Mode != config.RegistrationInvite {
+		return nil
+	}
+	if code == "" {
+		return ErrInviteRequired
+	}
+
+	ok, err := s.repo.ValidateInvite(ctx, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidInvite
+	}
+
+	return nil
+}
+
+// consumeInvite uses up one use of the invite in invite mode. It is a no-op in
+// other modes.
+func (s *service) consumeInvite(ctx context.Context, code string) error {
+	if s.authConfig.Registration.Mode != config.RegistrationInvite {
+		return nil
+	}
+	if code == "" {
+		return ErrInviteRequired
+	}
+
+	ok, err := s.repo.ConsumeInvite(ctx, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidInvite
+	}
+
+	return nil
+}
+
+// releaseInvite gives back a use taken by consumeInvite when registration fails
+// afterwards.
+func (s *service) releaseInvite(ctx context.Context, code string) {
+	if s.authConfig.Registration.Mode == config.RegistrationInvite {
+		_ = s.repo.ReleaseInvite(ctx, code)
+	}
+}
+
+func (s *service) CreateInvite(ctx context.Context, createdBy string, req *CreateInviteRequest) (*Invite, error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	invite := &Invite{
+		Code:      code,
+		MaxUses:   req.MaxUses,
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: &createdBy,
+	}
+	if invite.MaxUses == 0 {
+		invite.MaxUses = 1
+	}
+
+	err = s.repo.CreateInvite(ctx, invite)
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+func generateInviteCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
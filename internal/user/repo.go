@@ -4,20 +4,72 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
 )
 
 type Repository interface {
 	Create(ctx context.Context, user *User) error
+	Register(ctx context.Context, user *User, inviteCode string, consents []Consent) error
+	CreateGuest(ctx context.Context, user *User) error
+	UpgradeGuest(ctx context.Context, userID, email, username, passwordHash string) (bool, error)
+	DeleteInactiveGuests(ctx context.Context, cutoff time.Time) (int64, error)
 	GetByEmail(ctx context.Context, email string) (*User, error)
 	GetByID(ctx context.Context, id string) (*User, error)
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	FindSimilarUsername(ctx context.Context, username, exceptUserID string) (string, error)
+	GetPublicProfile(ctx context.Context, username string) (*PublicProfile, error)
+	ListUsers(ctx context.Context, q *ListUsersQuery) ([]User, int, error)
+	Search(ctx context.Context, q *SearchUsersQuery) ([]UserSearchResult, string, error)
+	ImportUsers(ctx context.Context, rows []ImportRow, onDuplicate string, dryRun bool) (*ImportResult, error)
+	StreamUsers(ctx context.Context, fn func(*User) error) error
+	UpdateUsername(ctx context.Context, userID, username string) error
+	UpdateProfile(ctx context.Context, userID string, patch *UpdateProfileRequest) error
+	SetSuspended(ctx context.Context, userID string, suspended bool) error
+	UpdateAvatar(ctx context.Context, userID, avatarKey string) error
+	GetDeletedByID(ctx context.Context, id string) (*User, error)
+	SoftDeleteUser(ctx context.Context, userID string) error
+	RestoreUser(ctx context.Context, userID string) (bool, error)
+	DeleteUser(ctx context.Context, userID string) error
 	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
 	GetRefreshToken(ctx context.Context, token string) (*RefreshToken, error)
 	RevokeRefreshToken(ctx context.Context, token string) error
 	RevokeAllUserTokens(ctx context.Context, userID string) error
-	UpdatePassword(ctx context.Context, userID, passwordHash string) error
+	RevokeOtherSessions(ctx context.Context, userID, sessionID string) error
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	IsSessionActive(ctx context.Context, userID, sessionID string) (bool, error)
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+	SetPasswordResetRequired(ctx context.Context, userID string) error
+	UpdateEmail(ctx context.Context, userID, email string) error
+	UpdateLastLogin(ctx context.Context, userID string) error
+	CreateLoginEvent(ctx context.Context, event *LoginEvent) error
+	ListLoginEvents(ctx context.Context, userID string, limit, offset int) ([]LoginEvent, int, error)
+	CountSuccessfulLogins(ctx context.Context, userID, ip, userAgent string) (int, int, error)
+	UpdatePassword(ctx context.Context, userID, passwordHash string, historySize int) error
+	GetPasswordHistory(ctx context.Context, userID string, limit int) ([]string, error)
+	CreateInvite(ctx context.Context, invite *Invite) error
+	ListInvites(ctx context.Context, limit, offset int) ([]Invite, int, error)
+	RevokeInvite(ctx context.Context, id string) (bool, error)
+	ValidateInvite(ctx context.Context, code string) (bool, error)
+	ConsumeInvite(ctx context.Context, code string) (bool, error)
+	ReleaseInvite(ctx context.Context, code string) error
+	CreateLegalDocument(ctx context.Context, doc *LegalDocument) error
+	GetLegalDocument(ctx context.Context, docType, version string) (*LegalDocument, error)
+	GetLegalDocuments(ctx context.Context, ids []string) ([]LegalDocument, error)
+	ListCurrentLegalDocuments(ctx context.Context) ([]LegalDocument, error)
+	ListRequiredLegalDocuments(ctx context.Context) ([]LegalDocument, error)
+	CreateConsents(ctx context.Context, consents []Consent) error
+	ListConsents(ctx context.Context, userID string) ([]Consent, error)
+	ScheduleDeletion(ctx context.Context, userID string, at time.Time) error
+	CancelDeletion(ctx context.Context, userID string, scheduledAt time.Time) (bool, error)
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, []string, error)
 }
 
 type repository struct {
@@ -25,6 +77,19 @@ type repository struct {
 	sb squirrel.StatementBuilderType
 }
 
+// userColumns lists the users columns explicitly because guest rows have a NULL
+// email.
+var userColumns = []string{
+	"id", "COALESCE(email, '') AS email", "username", "display_name", "bio", "locale", "timezone",
+	"profile_visibility", "role", "password_hash",
+	"password_changed_at", "password_reset_required", "is_guest", "created_at", "last_login",
+	"deletion_scheduled_at", "suspended_at", "avatar_key", "deleted_at",
+}
+
+// notDeleted leaves out soft-deleted users. Every users query applies it unless
+// it deals with deleted users on purpose.
+var notDeleted = squirrel.Eq{"deleted_at": nil}
+
 func NewRepository(db *sqlx.DB) Repository {
 	return &repository{
 		db: db,
@@ -33,21 +98,126 @@ func NewRepository(db *sqlx.DB) Repository {
 }
 
 func (r *repository) Create(ctx context.Context, user *User) error {
+	return r.createUser(ctx, r.db, user)
+}
+
+// Register creates a user together with their consents in one transaction.
+// When inviteCode is set, one use of the invite is taken in the same
+// transaction, and ErrInvalidInvite is returned if it can't be used. Nothing is
+// written unless every step succeeds.
+func (r *repository) Register(ctx context.Context, user *User, inviteCode string, consents []Consent) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if inviteCode != "" {
+		var ok bool
+		ok, err = r.consumeInvite(ctx, tx, inviteCode)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidInvite
+		}
+	}
+
+	err = r.createUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	for i := range consents {
+		consents[i].UserID = user.ID
+	}
+	err = r.createConsents(ctx, tx, consents)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *repository) createUser(ctx context.Context, db sqlx.QueryerContext, user *User) error {
+	query, args, err := r.sb.Insert("users").
+		Columns("email", "username", "role", "password_hash").
+		Values(user.Email, user.Username, user.Role, user.PasswordHash).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	err = db.QueryRowxContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
+	return uniqueUserError(err)
+}
+
+func (r *repository) CreateGuest(ctx context.Context, user *User) error {
 	query, args, err := r.sb.Insert("users").
-		Columns("email", "username", "password_hash").
-		Values(user.Email, user.Username, user.PasswordHash).
+		Columns("username", "role", "password_hash", "is_guest").
+		Values(user.Username, user.Role, "", true).
 		Suffix("RETURNING id, created_at").
 		ToSql()
 	if err != nil {
 		return err
 	}
 
+	user.IsGuest = true
 	return r.db.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt)
 }
 
+// UpgradeGuest attaches credentials to a guest account. It reports false if the
+// user does not exist or is not a guest.
+func (r *repository) UpgradeGuest(ctx context.Context, userID, email, username, passwordHash string) (bool, error) {
+	query, args, err := r.sb.Update("users").
+		Set("email", email).
+		Set("username", username).
+		Set("password_hash", passwordHash).
+		Set("password_changed_at", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Set("is_guest", false).
+		Where(squirrel.Eq{"id": userID, "is_guest": true}).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, uniqueUserError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// DeleteInactiveGuests removes guests whose last activity is before cutoff and
+// returns how many were deleted.
+func (r *repository) DeleteInactiveGuests(ctx context.Context, cutoff time.Time) (int64, error) {
+	query, args, err := r.sb.Delete("users").
+		Where(squirrel.Eq{"is_guest": true}).
+		Where(squirrel.Lt{"COALESCE(last_login, created_at)": cutoff}).
+		ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
 func (r *repository) GetByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
-	query, args, err := r.sb.Select("*").From("users").Where(squirrel.Eq{"email": email}).ToSql()
+	query, args, err := r.sb.Select(userColumns...).From("users").Where(squirrel.Eq{"lower(email)": email}).Where(notDeleted).ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +235,7 @@ func (r *repository) GetByEmail(ctx context.Context, email string) (*User, error
 
 func (r *repository) GetByID(ctx context.Context, id string) (*User, error) {
 	var user User
-	query, args, err := r.sb.Select("*").From("users").Where(squirrel.Eq{"id": id}).ToSql()
+	query, args, err := r.sb.Select(userColumns...).From("users").Where(squirrel.Eq{"id": id}).Where(notDeleted).ToSql()
 	if err != nil {
 		return nil, err
 	}
@@ -81,27 +251,75 @@ func (r *repository) GetByID(ctx context.Context, id string) (*User, error) {
 	return &user, nil
 }
 
-func (r *repository) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
-	query, args, err := r.sb.Insert("refresh_tokens").
-		Columns("user_id", "token", "expires_at").
-		Values(token.UserID, token.Token, token.ExpiresAt).
-		Suffix("RETURNING id, created_at").
-		ToSql()
+func (r *repository) GetByUsername(ctx context.Context, username string) (*User, error) {
+	var user User
+	query, args, err := r.sb.Select(userColumns...).From("users").Where(squirrel.Eq{"lower(username)": username}).Where(notDeleted).ToSql()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return r.db.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+	err = r.db.GetContext(ctx, &user, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
 }
 
-func (r *repository) GetRefreshToken(ctx context.Context, token string) (*RefreshToken, error) {
-	var rt RefreshToken
-	query, args, err := r.sb.Select("*").From("refresh_tokens").Where(squirrel.Eq{"token": token}).ToSql()
+// FindSimilarUsername returns the username of a live user other than
+// exceptUserID whose username equals username or only differs from it by
+// lookalike characters, or "" if there is none. Lookalikes share the result of
+// the username_skeleton database function, which is unique among live users.
+func (r *repository) FindSimilarUsername(ctx context.Context, username, exceptUserID string) (string, error) {
+	find := r.sb.Select("username").
+		From("users").
+		Where("username_skeleton(username) = username_skeleton(?)", username).
+		Where(notDeleted).
+		Limit(1)
+	if exceptUserID != "" {
+		find = find.Where(squirrel.NotEq{"id": exceptUserID})
+	}
+
+	query, args, err := find.ToSql()
+	if err != nil {
+		return "", err
+	}
+
+	var existing string
+	err = r.db.GetContext(ctx, &existing, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return existing, nil
+}
+
+// GetPublicProfile returns the profile of an active, non-guest user who made it
+// public, or nil.
+func (r *repository) GetPublicProfile(ctx context.Context, username string) (*PublicProfile, error) {
+	var profile PublicProfile
+	query, args, err := r.sb.Select("id", "username", "display_name", "bio", "created_at", "avatar_key").
+		From("users").
+		Where(squirrel.Eq{
+			"lower(username)":       username,
+			"profile_visibility":    ProfileVisibilityPublic,
+			"is_guest":              false,
+			"suspended_at":          nil,
+			"deletion_scheduled_at": nil,
+		}).
+		Where(notDeleted).
+		ToSql()
 	if err != nil {
 		return nil, err
 	}
 
-	err = r.db.GetContext(ctx, &rt, query, args...)
+	err = r.db.GetContext(ctx, &profile, query, args...)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -109,26 +327,451 @@ func (r *repository) GetRefreshToken(ctx context.Context, token string) (*Refres
 		return nil, err
 	}
 
-	return &rt, nil
+	return &profile, nil
 }
 
-func (r *repository) RevokeRefreshToken(ctx context.Context, token string) error {
-	query, args, err := r.sb.Update("refresh_tokens").
-		Set("revoked", true).
-		Where(squirrel.Eq{"token": token}).
+// userSortColumns maps ListUsersQuery.Sort values to ORDER BY expressions.
+var userSortColumns = map[string]string{
+	"created_at": "created_at",
+	"last_login": "last_login",
+	"email":      "email",
+	"username":   "username",
+}
+
+func (r *repository) ListUsers(ctx context.Context, q *ListUsersQuery) ([]User, int, error) {
+	where := squirrel.And{}
+	if q.Search != "" {
+		pattern := "%" + escapeLike(q.Search) + "%"
+		where = append(where, squirrel.Or{
+			squirrel.ILike{"email": pattern},
+			squirrel.ILike{"username": pattern},
+		})
+	}
+	if q.Role != "" {
+		where = append(where, squirrel.Eq{"role": q.Role})
+	}
+	switch q.Status {
+	case UserStatusActive:
+		where = append(where, squirrel.Eq{"suspended_at": nil, "deletion_scheduled_at": nil, "is_guest": false})
+	case UserStatusSuspended:
+		where = append(where, squirrel.NotEq{"suspended_at": nil})
+	case UserStatusGuest:
+		where = append(where, squirrel.Eq{"is_guest": true})
+	case UserStatusPendingDeletion:
+		where = append(where, squirrel.NotEq{"deletion_scheduled_at": nil})
+	case UserStatusDeleted:
+		where = append(where, squirrel.NotEq{"deleted_at": nil})
+	}
+	// Soft-deleted users are only listed when asked for
+	if q.Status != UserStatusDeleted {
+		where = append(where, notDeleted)
+	}
+
+	var total int
+	query, args, err := r.sb.Select("COUNT(*)").From("users").Where(where).ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.GetContext(ctx, &total, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort, ok := userSortColumns[q.Sort]
+	if !ok {
+		sort = userSortColumns["created_at"]
+	}
+	order := "DESC"
+	if q.Order == "asc" {
+		order = "ASC"
+	}
+
+	users := []User{}
+	query, args, err = r.sb.Select(userColumns...).
+		From("users").
+		Where(where).
+		OrderBy(sort+" "+order+" NULLS LAST", "id").
+		Limit(uint64(q.PerPage)).
+		Offset(uint64(q.Offset())).
+		ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.SelectContext(ctx, &users, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// Search ranks users by full-text rank plus the best trigram similarity of
+// their names and email. Results are paged by a cursor on (score, id), and the
+// returned cursor is empty on the last page.
+func (r *repository) Search(ctx context.Context, q *SearchUsersQuery) ([]UserSearchResult, string, error) {
+	pattern := "%" + escapeLike(q.Q) + "%"
+	tsQuery := "websearch_to_tsquery('simple', ?)"
+
+	score := squirrel.Expr(
+		"ts_rank(search_vector, "+tsQuery+") + GREATEST(similarity(username, ?), similarity(display_name, ?), similarity(COALESCE(email, ''), ?))",
+		q.Q, q.Q, q.Q, q.Q,
+	)
+	matches := squirrel.Or{
+		squirrel.Expr("search_vector @@ "+tsQuery, q.Q),
+		squirrel.ILike{"email": pattern},
+		squirrel.ILike{"username": pattern},
+		squirrel.ILike{"display_name": pattern},
+		squirrel.Expr("username % ?", q.Q),
+		squirrel.Expr("display_name % ?", q.Q),
+	}
+
+	ranked := squirrel.Select(userColumns...).
+		Column(squirrel.Alias(score, "score")).
+		From("users").
+		Where(matches).
+		Where(notDeleted)
+
+	page := r.sb.Select("*").
+		FromSelect(ranked, "ranked").
+		OrderBy("score DESC", "id DESC").
+		Limit(uint64(q.Limit + 1))
+
+	if q.Cursor != "" {
+		cursor, err := decodeSearchCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		page = page.Where("(score, id) < (?::real, ?::uuid)", cursor.Score, cursor.ID)
+	}
+
+	query, args, err := page.ToSql()
+	if err != nil {
+		return nil, "", err
+	}
+
+	results := []UserSearchResult{}
+	err = r.db.SelectContext(ctx, &results, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	var next string
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+		next = encodeSearchCursor(&results[q.Limit-1])
+	}
+
+	return results, next, nil
+}
+
+// ImportUsers loads rows with COPY into a temporary table and creates or
+// updates users from there in a single transaction, which is rolled back on
+// dryRun. The statements are written out because they run on the underlying pgx
+// connection, which COPY requires.
+func (r *repository) ImportUsers(ctx context.Context, rows []ImportRow, onDuplicate string, dryRun bool) (*ImportResult, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var result *ImportResult
+	err = conn.Raw(func(driverConn interface{}) error {
+		var importErr error
+		result, importErr = importUsers(ctx, driverConn.(*stdlib.Conn).Conn(), rows, onDuplicate, dryRun)
+		return importErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// importDuplicate is an imported row whose email or username is taken.
+type importDuplicate struct {
+	line          int
+	email         string
+	emailOwner    *string
+	usernameOwner *string
+}
+
+func importUsers(ctx context.Context, conn *pgx.Conn, rows []ImportRow, onDuplicate string, dryRun bool) (*ImportResult, error) {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE user_import (
+		line INTEGER NOT NULL,
+		email TEXT NOT NULL,
+		username TEXT NOT NULL,
+		display_name TEXT NOT NULL,
+		role TEXT NOT NULL
+	) ON COMMIT DROP`)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"user_import"},
+		[]string{"line", "email", "username", "display_name", "role"},
+		pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
+			return []interface{}{rows[i].Line, rows[i].Email, rows[i].Username, rows[i].DisplayName, rows[i].Role}, nil
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	dupRows, err := tx.Query(ctx, `
+		SELECT i.line, i.email, by_email.id::text, by_username.id::text
+		FROM user_import i
+		LEFT JOIN users by_email ON lower(by_email.email) = i.email AND by_email.deleted_at IS NULL
+		LEFT JOIN users by_username ON username_skeleton(by_username.username) = username_skeleton(i.username)
+			AND by_username.deleted_at IS NULL
+		WHERE by_email.id IS NOT NULL OR by_username.id IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	duplicates, err := pgx.CollectRows(dupRows, func(row pgx.CollectableRow) (importDuplicate, error) {
+		var d importDuplicate
+		scanErr := row.Scan(&d.line, &d.email, &d.emailOwner, &d.usernameOwner)
+		return d, scanErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// ParseImport only catches exact repeats, not usernames that differ by
+	// lookalike characters
+	lookalikeRows, err := tx.Query(ctx, `
+		SELECT line, email, first_line FROM (
+			SELECT line, email, first_value(line) OVER (PARTITION BY username_skeleton(username) ORDER BY line) AS first_line
+			FROM user_import
+		) lookalikes
+		WHERE line <> first_line
+		ORDER BY line`)
+	if err != nil {
+		return nil, err
+	}
+	lookalikes, err := pgx.CollectRows(lookalikeRows, func(row pgx.CollectableRow) (ImportRowError, error) {
+		var e ImportRowError
+		var firstLine int
+		scanErr := row.Scan(&e.Line, &e.Email, &firstLine)
+		e.Error = fmt.Sprintf("username is confusable with line %d", firstLine)
+		return e, scanErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{Errors: lookalikes}
+	notInserted := []int{}
+	toUpdate := []int{}
+	isLookalike := make(map[int]bool, len(lookalikes))
+	for _, e := range lookalikes {
+		notInserted = append(notInserted, e.Line)
+		isLookalike[e.Line] = true
+	}
+	for _, d := range duplicates {
+		if isLookalike[d.line] {
+			continue
+		}
+		notInserted = append(notInserted, d.line)
+
+		// Updating by email must not steal another user's username
+		sameOwner := d.usernameOwner == nil || (d.emailOwner != nil && *d.usernameOwner == *d.emailOwner)
+		switch {
+		case onDuplicate == DuplicateUpdate && d.emailOwner != nil && sameOwner:
+			toUpdate = append(toUpdate, d.line)
+		case onDuplicate == DuplicateSkip:
+			result.Skipped++
+		default:
+			reason := "username already taken"
+			if d.emailOwner != nil {
+				reason = "email already exists"
+			}
+			result.Errors = append(result.Errors, ImportRowError{Line: d.line, Email: d.email, Error: reason})
+		}
+	}
+	if onDuplicate == DuplicateFail && len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	created, err := tx.Query(ctx, `
+		INSERT INTO users (email, username, display_name, role, password_hash)
+		SELECT email, username, display_name, role, '' FROM user_import
+		WHERE line <> ALL($1)
+		ORDER BY line
+		RETURNING id::text, email, username`, notInserted)
+	if err != nil {
+		return nil, err
+	}
+	result.Created, err = pgx.CollectRows(created, scanImportedUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(toUpdate) > 0 {
+		updated, updateErr := tx.Query(ctx, `
+			UPDATE users u
+			SET username = i.username, display_name = i.display_name, role = i.role
+			FROM user_import i
+			WHERE lower(u.email) = i.email AND u.deleted_at IS NULL AND i.line = ANY($1)
+			RETURNING u.id::text, u.email, u.username`, toUpdate)
+		if updateErr != nil {
+			return nil, updateErr
+		}
+		result.Updated, err = pgx.CollectRows(updated, scanImportedUser)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit(ctx)
+}
+
+func scanImportedUser(row pgx.CollectableRow) (ImportedUser, error) {
+	var u ImportedUser
+	err := row.Scan(&u.ID, &u.Email, &u.Username)
+	return u, err
+}
+
+// StreamUsers calls fn for every registered user, oldest first, without loading
+// them all into memory. Guests are left out.
+func (r *repository) StreamUsers(ctx context.Context, fn func(*User) error) error {
+	query, args, err := r.sb.Select(userColumns...).
+		From("users").
+		Where(squirrel.Eq{"is_guest": false}).
+		Where(notDeleted).
+		OrderBy("created_at", "id").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := r.db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		err = rows.StructScan(&user)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&user)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards so s is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (r *repository) UpdateUsername(ctx context.Context, userID, username string) error {
+	query, args, err := r.sb.Update("users").
+		Set("username", username).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
 		ToSql()
 	if err != nil {
 		return err
 	}
 
 	_, err = r.db.ExecContext(ctx, query, args...)
+	if isUniqueViolation(err) {
+		return ErrUsernameTaken
+	}
 	return err
 }
 
-func (r *repository) RevokeAllUserTokens(ctx context.Context, userID string) error {
-	query, args, err := r.sb.Update("refresh_tokens").
-		Set("revoked", true).
-		Where(squirrel.Eq{"user_id": userID}).
+// UpdateProfile sets the non-nil fields of patch. A username taken concurrently
+// is reported as ErrUsernameTaken.
+func (r *repository) UpdateProfile(ctx context.Context, userID string, patch *UpdateProfileRequest) error {
+	columns := []struct {
+		name  string
+		value *string
+	}{
+		{"username", patch.Username},
+		{"display_name", patch.DisplayName},
+		{"bio", patch.Bio},
+		{"locale", patch.Locale},
+		{"timezone", patch.Timezone},
+		{"profile_visibility", patch.ProfileVisibility},
+	}
+
+	update := r.sb.Update("users").Where(squirrel.Eq{"id": userID}).Where(notDeleted)
+	changed := false
+	for _, column := range columns {
+		if column.value != nil {
+			update = update.Set(column.name, *column.value)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	query, args, err := update.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	if isUniqueViolation(err) {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint
+// violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// uniqueUserError turns a unique violation on users into ErrUserAlreadyExists
+// for the email index and ErrUsernameTaken for the username indexes. Other
+// errors are returned as is.
+func uniqueUserError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		return err
+	}
+	if pgErr.ConstraintName == "idx_users_email" {
+		return ErrUserAlreadyExists
+	}
+	return ErrUsernameTaken
+}
+
+func (r *repository) SetSuspended(ctx context.Context, userID string, suspended bool) error {
+	var suspendedAt interface{}
+	if suspended {
+		suspendedAt = squirrel.Expr("CURRENT_TIMESTAMP")
+	}
+
+	query, args, err := r.sb.Update("users").
+		Set("suspended_at", suspendedAt).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
 		ToSql()
 	if err != nil {
 		return err
@@ -138,10 +781,11 @@ func (r *repository) RevokeAllUserTokens(ctx context.Context, userID string) err
 	return err
 }
 
-func (r *repository) UpdatePassword(ctx context.Context, userID, passwordHash string) error {
+func (r *repository) UpdateAvatar(ctx context.Context, userID, avatarKey string) error {
 	query, args, err := r.sb.Update("users").
-		Set("password_hash", passwordHash).
+		Set("avatar_key", avatarKey).
 		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
 		ToSql()
 	if err != nil {
 		return err
@@ -150,3 +794,803 @@ func (r *repository) UpdatePassword(ctx context.Context, userID, passwordHash st
 	_, err = r.db.ExecContext(ctx, query, args...)
 	return err
 }
+
+func (r *repository) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	query, args, err := r.sb.Insert("refresh_tokens").
+		Columns("user_id", "session_id", "token", "jkt", "expires_at", "session_expires_at", "remember_me").
+		Values(token.UserID, token.SessionID, token.Token, token.JKT, token.ExpiresAt, token.SessionExpiresAt, token.RememberMe).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.db.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
+}
+
+func (r *repository) GetRefreshToken(ctx context.Context, token string) (*RefreshToken, error) {
+	var rt RefreshToken
+	query, args, err := r.sb.Select("*").From("refresh_tokens").Where(squirrel.Eq{"token": token}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.GetContext(ctx, &rt, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &rt, nil
+}
+
+func (r *repository) RevokeRefreshToken(ctx context.Context, token string) error {
+	query, args, err := r.sb.Update("refresh_tokens").
+		Set("revoked", true).
+		Where(squirrel.Eq{"token": token}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *repository) RevokeAllUserTokens(ctx context.Context, userID string) error {
+	query, args, err := r.sb.Update("refresh_tokens").
+		Set("revoked", true).
+		Where(squirrel.Eq{"user_id": userID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RevokeOtherSessions revokes every refresh token of the user except those
+// belonging to sessionID.
+func (r *repository) RevokeOtherSessions(ctx context.Context, userID, sessionID string) error {
+	query, args, err := r.sb.Update("refresh_tokens").
+		Set("revoked", true).
+		Where(squirrel.Eq{"user_id": userID}).
+		Where(squirrel.NotEq{"session_id": sessionID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *repository) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	query, args, err := r.sb.Update("refresh_tokens").
+		Set("revoked", true).
+		Where(squirrel.Eq{"user_id": userID, "session_id": sessionID}).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// IsSessionActive reports whether the session still has a usable refresh token.
+func (r *repository) IsSessionActive(ctx context.Context, userID, sessionID string) (bool, error) {
+	var active bool
+	query, args, err := r.sb.Select().
+		Column(squirrel.Expr("EXISTS (?)", squirrel.Select("1").
+			From("refresh_tokens").
+			Where(squirrel.Eq{"user_id": userID, "session_id": sessionID, "revoked": false}).
+			Where(squirrel.Expr("expires_at > CURRENT_TIMESTAMP")))).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	err = r.db.GetContext(ctx, &active, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
+
+func (r *repository) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	sessions := []Session{}
+	query, args, err := r.sb.Select(
+		"session_id",
+		"MIN(created_at) AS started_at",
+		"MAX(created_at) AS last_refreshed_at",
+		"MAX(expires_at) AS expires_at",
+		"BOOL_AND(revoked) AS ended",
+		"BOOL_OR(remember_me) AS remember_me",
+	).
+		From("refresh_tokens").
+		Where(squirrel.Eq{"user_id": userID}).
+		GroupBy("session_id").
+		OrderBy("started_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.SelectContext(ctx, &sessions, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (r *repository) SetPasswordResetRequired(ctx context.Context, userID string) error {
+	query, args, err := r.sb.Update("users").
+		Set("password_reset_required", true).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *repository) UpdateEmail(ctx context.Context, userID, email string) error {
+	query, args, err := r.sb.Update("users").
+		Set("email", email).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return uniqueUserError(err)
+}
+
+func (r *repository) UpdateLastLogin(ctx context.Context, userID string) error {
+	query, args, err := r.sb.Update("users").
+		Set("last_login", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *repository) CreateLoginEvent(ctx context.Context, event *LoginEvent) error {
+	query, args, err := r.sb.Insert("login_events").
+		Columns("user_id", "ip", "user_agent", "method", "success").
+		Values(event.UserID, event.IP, event.UserAgent, event.Method, event.Success).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.db.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+// ListLoginEvents returns a page of the user's login events, newest first, and
+// the total number of events.
+func (r *repository) ListLoginEvents(ctx context.Context, userID string, limit, offset int) ([]LoginEvent, int, error) {
+	var total int
+	query, args, err := r.sb.Select("COUNT(*)").From("login_events").Where(squirrel.Eq{"user_id": userID}).ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.GetContext(ctx, &total, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []LoginEvent{}
+	query, args, err = r.sb.Select("*").
+		From("login_events").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("created_at DESC").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.SelectContext(ctx, &events, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// CountSuccessfulLogins returns how many successful logins the user has from the
+// given IP and user agent, and how many they have in total.
+func (r *repository) CountSuccessfulLogins(ctx context.Context, userID, ip, userAgent string) (int, int, error) {
+	var counts struct {
+		FromDevice int `db:"from_device"`
+		Total      int `db:"total"`
+	}
+	query, args, err := r.sb.Select().
+		Column(squirrel.Expr("COUNT(*) FILTER (WHERE ip = ? AND user_agent = ?) AS from_device", ip, userAgent)).
+		Column("COUNT(*) AS total").
+		From("login_events").
+		Where(squirrel.Eq{"user_id": userID, "success": true}).
+		ToSql()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = r.db.GetContext(ctx, &counts, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return counts.FromDevice, counts.Total, nil
+}
+
+// UpdatePassword replaces the user's password hash, moving the previous hash into
+// password_history and keeping only the most recent historySize entries.
+func (r *repository) UpdatePassword(ctx context.Context, userID, passwordHash string, historySize int) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if historySize > 0 {
+		err = r.rotatePasswordHistory(ctx, tx, userID, historySize)
+		if err != nil {
+			return err
+		}
+	}
+
+	query, args, err := r.sb.Update("users").
+		Set("password_hash", passwordHash).
+		Set("password_changed_at", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Set("password_reset_required", false).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rotatePasswordHistory archives the current password hash, unless the user
+// has none yet, and prunes entries beyond the newest historySize.
+func (r *repository) rotatePasswordHistory(ctx context.Context, tx *sqlx.Tx, userID string, historySize int) error {
+	query, args, err := r.sb.Insert("password_history").
+		Columns("user_id", "password_hash").
+		Select(squirrel.Select("id", "password_hash").From("users").Where(squirrel.Eq{"id": userID}).Where(squirrel.NotEq{"password_hash": ""}).Where(notDeleted)).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	// Subqueries use the default "?" placeholders; the outer builder renumbers them.
+	keep := squirrel.Select("id").From("password_history").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("created_at DESC").
+		Limit(uint64(historySize))
+
+	query, args, err = r.sb.Delete("password_history").
+		Where(squirrel.Eq{"user_id": userID}).
+		Where(squirrel.Expr("id NOT IN (?)", keep)).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *repository) GetPasswordHistory(ctx context.Context, userID string, limit int) ([]string, error) {
+	var hashes []string
+	query, args, err := r.sb.Select("password_hash").
+		From("password_history").
+		Where(squirrel.Eq{"user_id": userID}).
+		OrderBy("created_at DESC").
+		Limit(uint64(limit)).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.SelectContext(ctx, &hashes, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+func (r *repository) CreateInvite(ctx context.Context, invite *Invite) error {
+	query, args, err := r.sb.Insert("invites").
+		Columns("code", "max_uses", "expires_at", "created_by").
+		Values(invite.Code, invite.MaxUses, invite.ExpiresAt, invite.CreatedBy).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.db.QueryRowContext(ctx, query, args...).Scan(&invite.ID, &invite.CreatedAt)
+}
+
+func (r *repository) ListInvites(ctx context.Context, limit, offset int) ([]Invite, int, error) {
+	var total int
+	query, args, err := r.sb.Select("COUNT(*)").From("invites").ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.GetContext(ctx, &total, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	invites := []Invite{}
+	query, args, err = r.sb.Select("*").
+		From("invites").
+		OrderBy("created_at DESC").
+		Limit(uint64(limit)).
+		Offset(uint64(offset)).
+		ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = r.db.SelectContext(ctx, &invites, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return invites, total, nil
+}
+
+func (r *repository) RevokeInvite(ctx context.Context, id string) (bool, error) {
+	query, args, err := r.sb.Update("invites").
+		Set("revoked", true).
+		Where(squirrel.Eq{"id": id}).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// usableInvite matches an invite that is neither revoked, used up nor expired.
+func usableInvite(code string) squirrel.And {
+	return squirrel.And{
+		squirrel.Eq{"code": code, "revoked": false},
+		squirrel.Expr("uses < max_uses"),
+		squirrel.Or{
+			squirrel.Eq{"expires_at": nil},
+			squirrel.Expr("expires_at > CURRENT_TIMESTAMP"),
+		},
+	}
+}
+
+// ValidateInvite reports whether an invite could be used, without using it.
+func (r *repository) ValidateInvite(ctx context.Context, code string) (bool, error) {
+	var valid bool
+	query, args, err := r.sb.Select().
+		Column(squirrel.Expr("EXISTS (?)", squirrel.Select("1").
+			From("invites").
+			Where(usableInvite(code)))).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	err = r.db.GetContext(ctx, &valid, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	return valid, nil
+}
+
+// ConsumeInvite atomically takes one use of a valid invite and reports whether
+// the invite could be used.
+func (r *repository) ConsumeInvite(ctx context.Context, code string) (bool, error) {
+	return r.consumeInvite(ctx, r.db, code)
+}
+
+func (r *repository) consumeInvite(ctx context.Context, db sqlx.ExecerContext, code string) (bool, error) {
+	query, args, err := r.sb.Update("invites").
+		Set("uses", squirrel.Expr("uses + 1")).
+		Where(usableInvite(code)).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (r *repository) ReleaseInvite(ctx context.Context, code string) error {
+	query, args, err := r.sb.Update("invites").
+		Set("uses", squirrel.Expr("uses - 1")).
+		Where(squirrel.Eq{"code": code}).
+		Where("uses > 0").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *repository) CreateLegalDocument(ctx context.Context, doc *LegalDocument) error {
+	query, args, err := r.sb.Insert("legal_documents").
+		Columns("type", "version", "url", "mandatory", "published_at").
+		Values(doc.Type, doc.Version, doc.URL, doc.Mandatory, doc.PublishedAt).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	return r.db.QueryRowContext(ctx, query, args...).Scan(&doc.ID, &doc.CreatedAt)
+}
+
+func (r *repository) GetLegalDocument(ctx context.Context, docType, version string) (*LegalDocument, error) {
+	var doc LegalDocument
+	query, args, err := r.sb.Select("*").
+		From("legal_documents").
+		Where(squirrel.Eq{"type": docType, "version": version}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.GetContext(ctx, &doc, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+func (r *repository) GetLegalDocuments(ctx context.Context, ids []string) ([]LegalDocument, error) {
+	docs := []LegalDocument{}
+	query, args, err := r.sb.Select("*").
+		From("legal_documents").
+		Where(squirrel.Eq{"id": ids}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.SelectContext(ctx, &docs, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+// ListCurrentLegalDocuments returns the latest published version of each
+// document type.
+func (r *repository) ListCurrentLegalDocuments(ctx context.Context) ([]LegalDocument, error) {
+	return r.listLatestLegalDocuments(ctx, squirrel.Expr("published_at <= CURRENT_TIMESTAMP"))
+}
+
+// ListRequiredLegalDocuments returns the latest published mandatory version of
+// each document type.
+func (r *repository) ListRequiredLegalDocuments(ctx context.Context) ([]LegalDocument, error) {
+	return r.listLatestLegalDocuments(ctx, squirrel.And{
+		squirrel.Eq{"mandatory": true},
+		squirrel.Expr("published_at <= CURRENT_TIMESTAMP"),
+	})
+}
+
+func (r *repository) listLatestLegalDocuments(ctx context.Context, pred squirrel.Sqlizer) ([]LegalDocument, error) {
+	docs := []LegalDocument{}
+	query, args, err := r.sb.Select("*").
+		Options("DISTINCT ON (type)").
+		From("legal_documents").
+		Where(pred).
+		OrderBy("type", "published_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.SelectContext(ctx, &docs, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+// CreateConsents records consents, skipping documents the user already accepted.
+func (r *repository) CreateConsents(ctx context.Context, consents []Consent) error {
+	return r.createConsents(ctx, r.db, consents)
+}
+
+func (r *repository) createConsents(ctx context.Context, db sqlx.ExecerContext, consents []Consent) error {
+	if len(consents) == 0 {
+		return nil
+	}
+
+	insert := r.sb.Insert("consents").
+		Columns("user_id", "document_id", "ip", "user_agent").
+		Suffix("ON CONFLICT (user_id, document_id) DO NOTHING")
+	for _, consent := range consents {
+		insert = insert.Values(consent.UserID, consent.DocumentID, consent.IP, consent.UserAgent)
+	}
+
+	query, args, err := insert.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (r *repository) ListConsents(ctx context.Context, userID string) ([]Consent, error) {
+	consents := []Consent{}
+	query, args, err := r.sb.Select(
+		"c.id", "c.user_id", "c.document_id", "d.type", "d.version", "d.published_at",
+		"c.ip", "c.user_agent", "c.accepted_at",
+	).
+		From("consents c").
+		Join("legal_documents d ON d.id = c.document_id").
+		Where(squirrel.Eq{"c.user_id": userID}).
+		OrderBy("c.accepted_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.SelectContext(ctx, &consents, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return consents, nil
+}
+
+func (r *repository) ScheduleDeletion(ctx context.Context, userID string, at time.Time) error {
+	query, args, err := r.sb.Update("users").
+		Set("deletion_scheduled_at", at).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// CancelDeletion clears the deletion scheduled for scheduledAt. It reports false
+// if none is scheduled, or one for another time.
+func (r *repository) CancelDeletion(ctx context.Context, userID string, scheduledAt time.Time) (bool, error) {
+	query, args, err := r.sb.Update("users").
+		Set("deletion_scheduled_at", nil).
+		Where(squirrel.Eq{"id": userID}).
+		// Tokens carry whole seconds
+		Where(squirrel.Expr("date_trunc('second', deletion_scheduled_at) = ?", scheduledAt)).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// PurgeDeletedUsers hard-deletes users whose deletion was scheduled before the
+// given time and returns how many were purged, along with the avatar keys left
+// to delete from storage.
+func (r *repository) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, []string, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var users []struct {
+		ID        string `db:"id"`
+		AvatarKey string `db:"avatar_key"`
+	}
+	query, args, err := r.sb.Select("id", "avatar_key").
+		From("users").
+		Where(squirrel.LtOrEq{"deletion_scheduled_at": before}).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = tx.SelectContext(ctx, &users, query, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(users) == 0 {
+		return 0, nil, nil
+	}
+
+	ids := make([]string, 0, len(users))
+	var avatarKeys []string
+	for _, u := range users {
+		ids = append(ids, u.ID)
+		if u.AvatarKey != "" {
+			avatarKeys = append(avatarKeys, u.AvatarKey)
+		}
+	}
+
+	n, err := r.deleteUsers(ctx, tx, ids)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return n, avatarKeys, tx.Commit()
+}
+
+// GetDeletedByID returns a soft-deleted user, or nil if the user does not
+// exist or is not deleted.
+func (r *repository) GetDeletedByID(ctx context.Context, id string) (*User, error) {
+	var user User
+	query, args, err := r.sb.Select(userColumns...).
+		From("users").
+		Where(squirrel.Eq{"id": id}).
+		Where(squirrel.NotEq{"deleted_at": nil}).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.db.GetContext(ctx, &user, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SoftDeleteUser hides a user from every other query, keeping the row and its
+// data until it is restored or purged.
+func (r *repository) SoftDeleteUser(ctx context.Context, userID string) error {
+	query, args, err := r.sb.Update("users").
+		Set("deleted_at", squirrel.Expr("CURRENT_TIMESTAMP")).
+		Where(squirrel.Eq{"id": userID}).
+		Where(notDeleted).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RestoreUser undoes a soft delete. It reports false if the user is not
+// deleted, and ErrUserAlreadyExists or ErrUsernameTaken if a live user has
+// taken the email or username in the meantime.
+func (r *repository) RestoreUser(ctx context.Context, userID string) (bool, error) {
+	query, args, err := r.sb.Update("users").
+		Set("deleted_at", nil).
+		Where(squirrel.Eq{"id": userID}).
+		Where(squirrel.NotEq{"deleted_at": nil}).
+		ToSql()
+	if err != nil {
+		return false, err
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, uniqueUserError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (r *repository) DeleteUser(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = r.deleteUsers(ctx, tx, []string{userID})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteUsers deletes the users. The foreign keys take care of the rest: their
+// own rows go with them through ON DELETE CASCADE, and invites they created are
+// kept with created_by set to NULL.
+func (r *repository) deleteUsers(ctx context.Context, tx *sqlx.Tx, ids []string) (int64, error) {
+	query, args, err := r.sb.Delete("users").Where(squirrel.Eq{"id": ids}).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,212 @@
+package user
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// newTestRepository starts Postgres in a container, applies every up
+// migration and returns a repository on it. The test is skipped without
+// Docker.
+func newTestRepository(t *testing.T) Repository {
+	t.Helper()
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+	ctx := context.Background()
+
+	container, err := postgres.Run(ctx, "postgres:16-alpine", postgres.BasicWaitStrategies())
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dsn, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	migrations, err := filepath.Glob("../../migrations/*.up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(migrations)
+	for _, path := range migrations {
+		migration, readErr := os.ReadFile(path)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if _, err = db.ExecContext(ctx, string(migration)); err != nil {
+			t.Fatalf("%s: %v", filepath.Base(path), err)
+		}
+	}
+
+	return NewRepository(db)
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	alice := &User{Email: "alice@example.com", Username: "alice", Role: RoleUser}
+	if err := repo.Create(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SoftDeleteUser(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Soft-deleted users disappear from lookups and listings
+	if u, err := repo.GetByID(ctx, alice.ID); err != nil || u != nil {
+		t.Errorf("GetByID() = %v, %v; want nil", u, err)
+	}
+	if u, err := repo.GetByEmail(ctx, alice.Email); err != nil || u != nil {
+		t.Errorf("GetByEmail() = %v, %v; want nil", u, err)
+	}
+	deleted, err := repo.GetDeletedByID(ctx, alice.ID)
+	if err != nil || deleted == nil || deleted.DeletedAt == nil {
+		t.Fatalf("GetDeletedByID() = %v, %v; want the deleted user", deleted, err)
+	}
+	users, total, err := repo.ListUsers(ctx, &ListUsersQuery{PageQuery: PageQuery{Page: 1, PerPage: 10}, Status: UserStatusDeleted})
+	if err != nil || total != 1 || users[0].ID != alice.ID {
+		t.Errorf("ListUsers(deleted) = %v, %d, %v; want alice", users, total, err)
+	}
+	if _, total, err = repo.ListUsers(ctx, &ListUsersQuery{PageQuery: PageQuery{Page: 1, PerPage: 10}}); err != nil || total != 0 {
+		t.Errorf("ListUsers() total = %d, %v; want 0", total, err)
+	}
+
+	// The email and username are free again, which blocks restoring alice
+	newAlice := &User{Email: "Alice@example.com", Username: "alice", Role: RoleUser}
+	if err = repo.Create(ctx, newAlice); err != nil {
+		t.Fatalf("Create() with a soft-deleted user's email error = %v", err)
+	}
+	if restored, restoreErr := repo.RestoreUser(ctx, alice.ID); restored || restoreErr != ErrUserAlreadyExists {
+		t.Errorf("RestoreUser() = %v, %v; want false, %v", restored, restoreErr, ErrUserAlreadyExists)
+	}
+
+	if err = repo.DeleteUser(ctx, newAlice.ID); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := repo.RestoreUser(ctx, alice.ID)
+	if err != nil || !restored {
+		t.Fatalf("RestoreUser() = %v, %v; want true", restored, err)
+	}
+	if u, getErr := repo.GetByEmail(ctx, alice.Email); getErr != nil || u == nil || u.ID != alice.ID {
+		t.Errorf("GetByEmail() after restore = %v, %v; want alice", u, getErr)
+	}
+	if restored, err = repo.RestoreUser(ctx, alice.ID); err != nil || restored {
+		t.Errorf("RestoreUser() of a live user = %v, %v; want false, nil", restored, err)
+	}
+}
+
+func TestPurgeRemovesSoftDeletedUser(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	bob := &User{Email: "bob@example.com", Username: "bob", Role: RoleUser}
+	if err := repo.Create(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SoftDeleteUser(ctx, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.DeleteUser(ctx, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := repo.GetDeletedByID(ctx, bob.ID); err != nil || u != nil {
+		t.Errorf("GetDeletedByID() after purge = %v, %v; want nil", u, err)
+	}
+}
+
+func TestRegisterWritesNothingOnFailure(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	err := repo.CreateInvite(ctx, &Invite{Code: "invite", MaxUses: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A consent for a document that does not exist fails the last insert
+	carol := &User{Email: "carol@example.com", Username: "carol", Role: RoleUser}
+	bogus := []Consent{{DocumentID: "00000000-0000-0000-0000-000000000000"}}
+	if err = repo.Register(ctx, carol, "invite", bogus); err == nil {
+		t.Fatal("Register() with an unknown document succeeded")
+	}
+	if u, getErr := repo.GetByEmail(ctx, carol.Email); getErr != nil || u != nil {
+		t.Errorf("GetByEmail() after failed Register = %v, %v; want nil", u, getErr)
+	}
+	if ok, validErr := repo.ValidateInvite(ctx, "invite"); validErr != nil || !ok {
+		t.Errorf("ValidateInvite() after failed Register = %v, %v; want the use given back", ok, validErr)
+	}
+
+	// The retry succeeds and uses up the invite
+	carol = &User{Email: "carol@example.com", Username: "carol", Role: RoleUser}
+	if err = repo.Register(ctx, carol, "invite", nil); err != nil {
+		t.Fatal(err)
+	}
+	dave := &User{Email: "dave@example.com", Username: "dave", Role: RoleUser}
+	if err = repo.Register(ctx, dave, "invite", nil); err != ErrInvalidInvite {
+		t.Errorf("Register() with a used-up invite error = %v; want %v", err, ErrInvalidInvite)
+	}
+}
+
+func TestCancelDeletionMatchesScheduledTime(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	erin := &User{Email: "erin@example.com", Username: "erin", Role: RoleUser}
+	if err := repo.Create(ctx, erin); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Now().Add(time.Hour)
+	if err := repo.ScheduleDeletion(ctx, erin.ID, at); err != nil {
+		t.Fatal(err)
+	}
+
+	if cancelled, err := repo.CancelDeletion(ctx, erin.ID, at.Add(-time.Minute).Truncate(time.Second)); err != nil || cancelled {
+		t.Errorf("CancelDeletion() for another time = %v, %v; want false", cancelled, err)
+	}
+	if cancelled, err := repo.CancelDeletion(ctx, erin.ID, at.Truncate(time.Second)); err != nil || !cancelled {
+		t.Errorf("CancelDeletion() = %v, %v; want true", cancelled, err)
+	}
+}
+
+func TestUpdatePasswordSkipsMissingHashInHistory(t *testing.T) {
+	repo := newTestRepository(t)
+	ctx := context.Background()
+
+	// Imported users start without a password
+	frank := &User{Email: "frank@example.com", Username: "frank", Role: RoleUser}
+	if err := repo.Create(ctx, frank); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdatePassword(ctx, frank.ID, "first", 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdatePassword(ctx, frank.ID, "second", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := repo.GetPasswordHistory(ctx, frank.ID, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0] != "first" {
+		t.Errorf("GetPasswordHistory() = %q; want only the first password", history)
+	}
+}
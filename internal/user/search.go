@@ -0,0 +1,129 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SearchUsersQuery finds users by partial or fuzzy matches on email, username
+// and display name, best matches first.
+type SearchUsersQuery struct {
+	Q      string `query:"q" validate:"required,min=2,max=100"`
+	Cursor string `query:"cursor"`
+	Limit  int    `query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+// Normalize fills in defaults for omitted values.
+func (q *SearchUsersQuery) Normalize() {
+	q.Q = strings.TrimSpace(q.Q)
+	if q.Limit == 0 {
+		q.Limit = 20
+	}
+}
+
+type UserSearchResult struct {
+	User
+	// Score combines full-text rank and trigram similarity; higher is better.
+	Score float64 `db:"score" json:"score"`
+	// Highlights holds the matching fields, HTML-escaped, with matches wrapped
+	// in <mark> tags.
+	Highlights map[string]string `db:"-" json:"highlights,omitempty"`
+}
+
+// searchCursor is the position after the last result of a page.
+type searchCursor struct {
+	Score float64 `json:"s"`
+	ID    string  `json:"id"`
+}
+
+func encodeSearchCursor(result *UserSearchResult) string {
+	data, _ := json.Marshal(searchCursor{Score: result.Score, ID: result.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(s string) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor searchCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || uuid.Validate(cursor.ID) != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// Highlight fills in Highlights for every field containing a search term.
+func (r *UserSearchResult) Highlight(q string) {
+	terms := searchTerms(q)
+	if terms == nil {
+		return
+	}
+
+	fields := map[string]string{
+		"email":        r.Email,
+		"username":     r.Username,
+		"display_name": r.DisplayName,
+	}
+	for name, value := range fields {
+		if marked, ok := highlight(value, terms); ok {
+			if r.Highlights == nil {
+				r.Highlights = make(map[string]string)
+			}
+			r.Highlights[name] = marked
+		}
+	}
+}
+
+// searchTerms matches any word of q case-insensitively, preferring longer
+// words. Quotes, OR and excluded words of the web search syntax are skipped.
+func searchTerms(q string) *regexp.Regexp {
+	var words []string
+	for _, word := range strings.Fields(q) {
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		word = strings.Trim(word, `"`)
+		if word != "" && !strings.EqualFold(word, "or") {
+			words = append(words, regexp.QuoteMeta(word))
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+	return regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+}
+
+func highlight(text string, terms *regexp.Regexp) (string, bool) {
+	matches := terms.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+
+	return b.String(), true
+}
@@ -4,10 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html"
+	"io"
+	"template/internal/config"
 	"template/internal/email"
 	"template/internal/jwt"
+	"template/internal/preferences"
+	"template/internal/storage"
 	"time"
 
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -15,78 +21,285 @@ var (
 	ErrUserAlreadyExists  = errors.New("user already exists")
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrInvalidToken       = errors.New("invalid token")
+	ErrPasswordReused     = errors.New("password was used recently")
+	ErrPasswordExpired    = errors.New("password expired")
+	ErrUserNotFound       = errors.New("user not found")
+
+	ErrInviteRequired        = errors.New("invite code required")
+	ErrInvalidInvite         = errors.New("invalid invite code")
+	ErrEmailDomainNotAllowed = errors.New("email domain not allowed")
+	ErrDisposableEmail       = errors.New("disposable email not allowed")
 )
 
 type Service interface {
-	Register(ctx context.Context, req *RegisterRequest) (*jwt.TokenPair, error)
-	Login(ctx context.Context, req *LoginRequest) (*jwt.TokenPair, error)
-	RefreshToken(ctx context.Context, refreshToken string) (*jwt.TokenPair, error)
+	Register(ctx context.Context, req *RegisterRequest, client ClientInfo) (*jwt.TokenPair, error)
+	Login(ctx context.Context, req *LoginRequest, client ClientInfo) (*jwt.TokenPair, error)
+	RefreshToken(ctx context.Context, refreshToken string, client ClientInfo) (*jwt.TokenPair, error)
+	Logout(ctx context.Context, refreshToken string) error
+	CreateSession(ctx context.Context, userID, method string, client ClientInfo) (*jwt.TokenPair, error)
+	IntrospectToken(ctx context.Context, token, hint string) (*TokenIntrospection, error)
+	RevokeToken(ctx context.Context, token, hint string) error
 	ForgotPassword(ctx context.Context, email string) error
 	ResetPassword(ctx context.Context, token, newPassword string) error
+	ChangePassword(ctx context.Context, userID, sessionID string, req *ChangePasswordRequest) error
+	RequestEmailChange(ctx context.Context, userID string, req *ChangeEmailRequest) error
+	ConfirmEmailChange(ctx context.Context, token string) error
+	ReportSession(ctx context.Context, token string) error
+	CreateInvite(ctx context.Context, createdBy string, req *CreateInviteRequest) (*Invite, error)
+	CreateGuest(ctx context.Context, client ClientInfo) (*jwt.TokenPair, error)
+	UpgradeGuest(ctx context.Context, userID string, req *UpgradeRequest) (bool, error)
+	CleanupGuests(ctx context.Context) (int64, error)
+	PublishLegalDocument(ctx context.Context, req *PublishDocumentRequest) (*LegalDocument, error)
+	GetConsentStatus(ctx context.Context, userID string) (*ConsentStatus, error)
+	HasPendingConsents(ctx context.Context, userID string) (bool, error)
+	AcceptConsents(ctx context.Context, userID string, req *AcceptConsentsRequest, client ClientInfo) error
+	DeleteAccount(ctx context.Context, userID string, req *DeleteAccountRequest) (*time.Time, error)
+	CancelDeletion(ctx context.Context, token string) (*User, error)
+	PurgeDeletedAccounts(ctx context.Context) (int64, error)
+	AdminUpdateUser(ctx context.Context, userID string, req *AdminUpdateUserRequest) (*User, error)
+	SetSuspended(ctx context.Context, actorID, userID string, suspended bool) error
+	ForcePasswordReset(ctx context.Context, userID string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+	AdminDeleteUser(ctx context.Context, actorID, userID string) error
+	RestoreUser(ctx context.Context, userID string) (*User, error)
+	PurgeUser(ctx context.Context, userID string) error
+	UpdateProfile(ctx context.Context, userID string, req *UpdateProfileRequest) (*User, error)
+	UpdateAvatar(ctx context.Context, userID string, data []byte) (*User, error)
+	RemoveAvatar(ctx context.Context, userID string) error
+	AvatarURLs(ctx context.Context, avatarKey string) (string, map[string]string, error)
+	ImportUsers(ctx context.Context, rows []ImportRow, rowErrors []ImportRowError, opts *ImportOptions) (*ImportReport, *ImportResult, error)
+	InviteImportedUsers(ctx context.Context, users []ImportedUser) (int, error)
+	QueueImportInvites(users []ImportedUser) bool
+	RunInviteWorker(ctx context.Context)
+	ExportUsers(ctx context.Context, w io.Writer) error
 }
 
+// dummyPasswordHash is compared against when a login email is unknown or its
+// account has no password, so the response time does not reveal either.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password-for-timing"), bcrypt.DefaultCost)
+
+// comparePassword checks login passwords. Tests replace it to count the
+// comparisons made for known and unknown emails.
+var comparePassword = bcrypt.CompareHashAndPassword
+
 type service struct {
-	repo         Repository
-	jwtSecret    string
-	emailSender  *email.Sender
-	frontendHost string
+	repo           Repository
+	jwtSecret      string
+	emailSender    email.Mailer
+	frontendHost   string
+	passwordPolicy config.PasswordConfig
+	authConfig     config.AuthConfig
+	storage        storage.Storage
+	preferences    preferences.Reader
+	// inviteQueue holds batches of imported users waiting for their
+	// invitation emails, sent by RunInviteWorker.
+	inviteQueue chan []ImportedUser
 }
 
-func NewService(repo Repository, jwtSecret string, emailSender *email.Sender, frontendHost string) Service {
+func NewService(
+	repo Repository,
+	jwtSecret string,
+	emailSender email.Mailer,
+	frontendHost string,
+	passwordPolicy config.PasswordConfig,
+	authConfig config.AuthConfig,
+	storage storage.Storage,
+	preferences preferences.Reader,
+) Service {
 	return &service{
-		repo:         repo,
-		jwtSecret:    jwtSecret,
-		emailSender:  emailSender,
-		frontendHost: frontendHost,
+		repo:           repo,
+		jwtSecret:      jwtSecret,
+		emailSender:    emailSender,
+		frontendHost:   frontendHost,
+		passwordPolicy: passwordPolicy,
+		authConfig:     authConfig,
+		storage:        storage,
+		preferences:    preferences,
+		inviteQueue:    make(chan []ImportedUser, inviteQueueSize),
 	}
 }
 
-func (s *service) Register(ctx context.Context, req *RegisterRequest) (*jwt.TokenPair, error) {
+// Register creates a user and returns a token pair. With enumeration protection
+// enabled it returns nil tokens and a nil error for new and existing emails and
+// for taken usernames; the outcome is only communicated by email.
+func (s *service) Register(ctx context.Context, req *RegisterRequest, client ClientInfo) (*jwt.TokenPair, error) {
+	req.Email = normalizeEmail(req.Email)
+	req.Username = normalizeUsername(req.Username)
+
+	err := s.checkEmailDomain(req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.checkReservedUsername(req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateUsername(req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	acceptedDocs, err := s.checkRegistrationConsents(ctx, req.Consents)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validateInvite(ctx, req.InviteCode)
+	if err != nil {
+		return nil, err
+	}
+
 	existingUser, err := s.repo.GetByEmail(ctx, req.Email)
 	if err != nil {
 		return nil, err
 	}
+
+	// Hash before branching so both paths spend the same time in bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
 	if existingUser != nil {
-		return nil, ErrUserAlreadyExists
+		if !s.authConfig.EnumerationProtection {
+			return nil, ErrUserAlreadyExists
+		}
+		return nil, s.sendAccountExistsEmail(existingUser)
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	err = s.checkUsernameAvailable(ctx, "", req.Username)
 	if err != nil {
+		if s.authConfig.EnumerationProtection && usernameUnavailable(err) {
+			return nil, s.sendUsernameUnavailableEmail(req.Email, req.Username)
+		}
 		return nil, err
 	}
 
 	user := &User{
 		Email:        req.Email,
 		Username:     req.Username,
+		Role:         RoleUser,
 		PasswordHash: string(hashedPassword),
 	}
 
-	err = s.repo.Create(ctx, user)
+	// The invite use, the user and their consents are written together, so a
+	// failure leaves nothing behind to block a retry
+	inviteCode := ""
+	if s.authConfig.Registration.Mode == config.RegistrationInvite {
+		inviteCode = req.InviteCode
+	}
+	err = s.repo.Register(ctx, user, inviteCode, newConsents("", acceptedDocs, client))
 	if err != nil {
 		return nil, err
 	}
 
-	return s.generateTokens(ctx, user.ID)
+	if s.authConfig.EnumerationProtection {
+		return nil, s.sendWelcomeEmail(user)
+	}
+
+	tokens, err := s.generateTokens(ctx, user, sessionState{}, client)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordLogin(ctx, user.ID, LoginMethodRegister, true, client)
+	return tokens, nil
+}
+
+func (s *service) sendWelcomeEmail(user *User) error {
+	loginLink := fmt.Sprintf("%s/login", s.frontendHost)
+	body := fmt.Sprintf("Your account has been created. <a href=\"%s\">Log in</a> to get started.", loginLink)
+
+	return s.emailSender.Send(user.Email, "Welcome", body)
+}
+
+func (s *service) sendAccountExistsEmail(user *User) error {
+	resetLink := fmt.Sprintf("%s/recover-password", s.frontendHost)
+	body := fmt.Sprintf("Someone tried to create an account with this email address, but you already have one. "+
+		"If this was you, <a href=\"%s\">recover your password</a> instead. Otherwise you can ignore this email.", resetLink)
+
+	return s.emailSender.Send(user.Email, "Registration Attempt", body)
+}
+
+func (s *service) sendUsernameUnavailableEmail(to, username string) error {
+	registerLink := fmt.Sprintf("%s/register", s.frontendHost)
+	body := fmt.Sprintf("Someone tried to create an account with this email address, but the username %s is not available. "+
+		"If this was you, <a href=\"%s\">sign up again</a> with a different username. Otherwise you can ignore this email.", username, registerLink)
+
+	return s.emailSender.Send(to, "Registration Attempt", body)
 }
 
-func (s *service) Login(ctx context.Context, req *LoginRequest) (*jwt.TokenPair, error) {
-	user, err := s.repo.GetByEmail(ctx, req.Email)
+func (s *service) Login(ctx context.Context, req *LoginRequest, client ClientInfo) (*jwt.TokenPair, error) {
+	user, err := s.repo.GetByEmail(ctx, normalizeEmail(req.Email))
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
+		// Burn the same bcrypt cost as a real comparison
+		_ = comparePassword(dummyPasswordHash, []byte(req.Password))
 		return nil, ErrInvalidCredentials
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
-	if err != nil {
+	// Imported users have no password until they set one; answer them like a
+	// wrong password, in the same time
+	hash := []byte(user.PasswordHash)
+	if len(hash) == 0 {
+		hash = dummyPasswordHash
+	}
+	err = comparePassword(hash, []byte(req.Password))
+	if err != nil || user.PasswordHash == "" {
+		s.recordLogin(ctx, user.ID, LoginMethodPassword, false, client)
 		return nil, ErrInvalidCredentials
 	}
 
-	return s.generateTokens(ctx, user.ID)
+	if user.DeletionScheduledAt != nil {
+		s.recordLogin(ctx, user.ID, LoginMethodPassword, false, client)
+		// The emailed link may have expired, so send a new one
+		err = s.sendDeletionScheduledEmail(user, *user.DeletionScheduledAt)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrAccountPendingDeletion
+	}
+
+	if s.mustResetPassword(user) {
+		s.recordLogin(ctx, user.ID, LoginMethodPassword, false, client)
+		// Force a change: the user must go through the reset flow before logging in again.
+		err = s.sendResetEmail(user)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrPasswordExpired
+	}
+
+	tokens, err := s.generateTokens(ctx, user, sessionState{RememberMe: req.RememberMe}, client)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordLogin(ctx, user.ID, LoginMethodPassword, true, client)
+	return tokens, nil
+}
+
+// recordLogin stores a login event and, on success, bumps users.last_login.
+// Failures are ignored so bookkeeping never blocks authentication.
+func (s *service) recordLogin(ctx context.Context, userID, method string, success bool, client ClientInfo) {
+	_ = s.repo.CreateLoginEvent(ctx, &LoginEvent{
+		UserID:    userID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Method:    method,
+		Success:   success,
+	})
+
+	if success {
+		_ = s.repo.UpdateLastLogin(ctx, userID)
+	}
 }
 
-func (s *service) RefreshToken(ctx context.Context, token string) (*jwt.TokenPair, error) {
+func (s *service) RefreshToken(ctx context.Context, token string, client ClientInfo) (*jwt.TokenPair, error) {
 	rt, err := s.repo.GetRefreshToken(ctx, token)
 	if err != nil {
 		return nil, err
@@ -99,6 +312,7 @@ func (s *service) RefreshToken(ctx context.Context, token string) (*jwt.TokenPai
 	if rt.Revoked {
 		// Token reused! Revoke all tokens for this user (Family Tracking)
 		_ = s.repo.RevokeAllUserTokens(ctx, rt.UserID)
+		s.recordLogin(ctx, rt.UserID, LoginMethodRefresh, false, client)
 		return nil, ErrInvalidToken
 	}
 
@@ -106,25 +320,224 @@ func (s *service) RefreshToken(ctx context.Context, token string) (*jwt.TokenPai
 		return nil, ErrInvalidToken
 	}
 
+	// A DPoP-bound refresh token is useless without a proof from the same key
+	if rt.JKT != "" && rt.JKT != client.JKT {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.repo.GetByID(ctx, rt.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+
 	// Revoke the used refresh token (Rotation)
 	err = s.repo.RevokeRefreshToken(ctx, token)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.generateTokens(ctx, rt.UserID)
+	tokens, err := s.generateTokens(ctx, user, sessionState{
+		ID:         rt.SessionID,
+		RememberMe: rt.RememberMe,
+		ExpiresAt:  rt.SessionExpiresAt,
+	}, client)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordLogin(ctx, rt.UserID, LoginMethodRefresh, true, client)
+	return tokens, nil
+}
+
+// CreateSession starts a session for a user authenticated by another flow, such
+// as the device authorization grant.
+func (s *service) CreateSession(ctx context.Context, userID, method string, client ClientInfo) (*jwt.TokenPair, error) {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	tokens, err := s.generateTokens(ctx, user, sessionState{}, client)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordLogin(ctx, user.ID, method, true, client)
+	return tokens, nil
+}
+
+// Logout ends the session the refresh token belongs to. Unknown tokens are ignored.
+func (s *service) Logout(ctx context.Context, token string) error {
+	rt, err := s.repo.GetRefreshToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if rt == nil {
+		return nil
+	}
+
+	return s.repo.RevokeSession(ctx, rt.UserID, rt.SessionID)
+}
+
+// IntrospectToken reports whether an access or refresh token is active. An access
+// token is only active while its session has not been revoked.
+func (s *service) IntrospectToken(ctx context.Context, token, hint string) (*TokenIntrospection, error) {
+	if hint != TokenHintRefreshToken {
+		claims := s.parseAccessToken(token)
+		if claims != nil {
+			active := true
+			if claims.SessionID != "" {
+				var err error
+				active, err = s.repo.IsSessionActive(ctx, claims.UserID, claims.SessionID)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !active {
+				return &TokenIntrospection{Active: false}, nil
+			}
+
+			result := &TokenIntrospection{
+				Active:    true,
+				Sub:       claims.UserID,
+				Scope:     claims.Scope,
+				TokenType: TokenHintAccessToken,
+				SessionID: claims.SessionID,
+			}
+			if claims.ExpiresAt != nil {
+				result.Exp = claims.ExpiresAt.Unix()
+			}
+			if claims.IssuedAt != nil {
+				result.Iat = claims.IssuedAt.Unix()
+			}
+			return result, nil
+		}
+	}
+
+	rt, err := s.repo.GetRefreshToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if rt == nil || rt.Revoked || time.Now().After(rt.ExpiresAt) {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	// A refresh token carries the scopes of the user's current role
+	user, err := s.repo.GetByID(ctx, rt.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return &TokenIntrospection{Active: false}, nil
+	}
+
+	return &TokenIntrospection{
+		Active:    true,
+		Sub:       rt.UserID,
+		Exp:       rt.ExpiresAt.Unix(),
+		Iat:       rt.CreatedAt.Unix(),
+		Scope:     scopeFor(user.Role),
+		TokenType: TokenHintRefreshToken,
+		SessionID: rt.SessionID,
+	}, nil
+}
+
+// RevokeToken revokes the session an access or refresh token belongs to, which
+// invalidates both kinds of token for it. Unknown tokens are ignored (RFC 7009).
+func (s *service) RevokeToken(ctx context.Context, token, hint string) error {
+	if hint != TokenHintRefreshToken {
+		claims := s.parseAccessToken(token)
+		if claims != nil {
+			if claims.SessionID == "" {
+				return nil
+			}
+			return s.repo.RevokeSession(ctx, claims.UserID, claims.SessionID)
+		}
+	}
+
+	return s.Logout(ctx, token)
+}
+
+// parseAccessToken returns the claims of a valid access token, or nil for
+// anything else including single-purpose tokens.
+func (s *service) parseAccessToken(token string) *jwt.Claims {
+	claims, err := jwt.ValidateAccessToken(token, s.jwtSecret)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// sessionState identifies the session tokens are issued for. A zero ID starts a
+// new session.
+type sessionState struct {
+	ID         string
+	RememberMe bool
+	// ExpiresAt is the absolute deadline, set when the session starts.
+	ExpiresAt time.Time
 }
 
-func (s *service) generateTokens(ctx context.Context, userID string) (*jwt.TokenPair, error) {
-	tokens, err := jwt.GenerateTokens(userID, s.jwtSecret)
+// generateTokens issues a token pair for the given session. The refresh token
+// expires after the policy's idle timeout, capped at the session's absolute
+// deadline. Starting a new session triggers a new-device alert when needed.
+func (s *service) generateTokens(ctx context.Context, user *User, session sessionState, client ClientInfo) (*jwt.TokenPair, error) {
+	if user.SuspendedAt != nil {
+		return nil, ErrAccountSuspended
+	}
+	// Restoring the account is only possible through the emailed link, which
+	// Login sends again
+	if user.DeletionScheduledAt != nil {
+		return nil, ErrAccountPendingDeletion
+	}
+	// Sessions can't outlive a flagged or expired password, or a reported
+	// session's siblings would keep refreshing
+	if s.mustResetPassword(user) {
+		return nil, ErrPasswordExpired
+	}
+
+	policy := s.authConfig.Session
+	if session.RememberMe {
+		policy = s.authConfig.RememberMeSession
+	}
+
+	now := time.Now()
+	newSession := session.ID == ""
+	if newSession {
+		session.ID = uuid.NewString()
+		session.ExpiresAt = now.Add(policy.AbsoluteLifetime)
+	}
+
+	expiresAt := now.Add(policy.IdleTimeout)
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	tokens, err := jwt.GenerateTokens(user.ID, s.jwtSecret, jwt.TokenOptions{
+		Role:      user.Role,
+		SessionID: session.ID,
+		Scope:     scopeFor(user.Role),
+		JKT:       client.JKT,
+		TTL:       s.authConfig.AccessTokenTTL,
+	})
 	if err != nil {
 		return nil, err
 	}
+	tokens.RefreshExpiresIn = int(expiresAt.Sub(now).Seconds())
 
 	refreshToken := &RefreshToken{
-		UserID:    userID,
-		Token:     tokens.RefreshToken,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
+		UserID:           user.ID,
+		SessionID:        session.ID,
+		Token:            tokens.RefreshToken,
+		JKT:              client.JKT,
+		ExpiresAt:        expiresAt,
+		SessionExpiresAt: session.ExpiresAt,
+		RememberMe:       session.RememberMe,
 	}
 
 	err = s.repo.CreateRefreshToken(ctx, refreshToken)
@@ -132,11 +545,82 @@ func (s *service) generateTokens(ctx context.Context, userID string) (*jwt.Token
 		return nil, err
 	}
 
+	if newSession {
+		s.alertNewDevice(ctx, user, session.ID, client)
+	}
+
 	return tokens, nil
 }
 
+// alertNewDevice emails the user when a session is started from an IP and user
+// agent combination that has never signed in successfully before. The very first
+// sign-in of an account is not alerted on, and guests have no email to alert.
+// Errors are ignored so alerts never block authentication.
+func (s *service) alertNewDevice(ctx context.Context, user *User, sessionID string, client ClientInfo) {
+	if user.IsGuest {
+		return
+	}
+
+	prefs, err := s.preferences.Get(ctx, user.ID)
+	if err == nil && !prefs.Notifications.NewDeviceLogin {
+		return
+	}
+
+	fromDevice, total, err := s.repo.CountSuccessfulLogins(ctx, user.ID, client.IP, client.UserAgent)
+	if err != nil || total == 0 || fromDevice > 0 {
+		return
+	}
+
+	token, err := jwt.GenerateSessionReportToken(user.ID, sessionID, s.jwtSecret, s.authConfig.SessionReportTokenTTL)
+	if err != nil {
+		return
+	}
+
+	reportLink := fmt.Sprintf("%s/report-session?token=%s", s.frontendHost, token)
+	body := fmt.Sprintf("We noticed a new sign-in to your account.<br><br>"+
+		"IP address: %s<br>Device: %s<br>Time: %s<br><br>"+
+		"If this was you, you can ignore this email. "+
+		"If not, <a href=\"%s\">click here</a> to sign out everywhere and reset your password.",
+		html.EscapeString(client.IP), html.EscapeString(client.UserAgent), time.Now().UTC().Format(time.RFC1123), reportLink)
+
+	// Don't make the login wait on SMTP
+	go func() {
+		_ = s.emailSender.Send(user.Email, "New Sign-In to Your Account", body)
+	}()
+}
+
+// ReportSession handles a "this wasn't me" link: every session is revoked, as
+// the attacker may hold more than the reported one, and the user must reset
+// their password before logging in again.
+func (s *service) ReportSession(ctx context.Context, tokenString string) error {
+	claims, err := jwt.ValidateToken(tokenString, s.jwtSecret)
+	if err != nil || claims.Subject != jwt.SubjectSessionReport || claims.SessionID == "" {
+		return ErrInvalidToken
+	}
+
+	user, err := s.repo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidToken
+	}
+
+	err = s.repo.RevokeAllUserTokens(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.SetPasswordResetRequired(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.sendResetEmail(user)
+}
+
 func (s *service) ForgotPassword(ctx context.Context, email string) error {
-	user, err := s.repo.GetByEmail(ctx, email)
+	user, err := s.repo.GetByEmail(ctx, normalizeEmail(email))
 	if err != nil {
 		return err
 	}
@@ -144,8 +628,12 @@ func (s *service) ForgotPassword(ctx context.Context, email string) error {
 		return nil
 	}
 
+	return s.sendResetEmail(user)
+}
+
+func (s *service) sendResetEmail(user *User) error {
 	// Generate a short-lived token
-	token, err := jwt.GenerateResetToken(user.ID, s.jwtSecret)
+	token, err := jwt.GenerateResetToken(user.ID, s.jwtSecret, s.authConfig.ResetTokenTTL)
 	if err != nil {
 		return err
 	}
@@ -154,19 +642,200 @@ func (s *service) ForgotPassword(ctx context.Context, email string) error {
 	resetLink := fmt.Sprintf("%s/reset-password?token=%s", s.frontendHost, token)
 	body := fmt.Sprintf("Click here to reset your password: <a href=\"%s\">Reset Password</a>", resetLink)
 
-	return s.emailSender.Send(email, "Password Recovery", body)
+	return s.emailSender.Send(user.Email, "Password Recovery", body)
 }
 
+// ResetPassword sets a new password with a token from a password recovery or
+// an import invitation email. An invitation only sets the first password.
 func (s *service) ResetPassword(ctx context.Context, tokenString, newPassword string) error {
 	claims, err := jwt.ValidateToken(tokenString, s.jwtSecret)
+	if err != nil || (claims.Subject != jwt.SubjectPasswordReset && claims.Subject != jwt.SubjectImportInvite) {
+		return ErrInvalidToken
+	}
+
+	user, err := s.repo.GetByID(ctx, claims.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidToken
+	}
+	if claims.Subject == jwt.SubjectImportInvite && user.PasswordHash != "" {
+		return ErrInvalidToken
+	}
+
+	err = s.setPassword(ctx, user, newPassword)
+	if err != nil {
+		return err
+	}
+
+	// Whoever knew the old password is signed out
+	return s.repo.RevokeAllUserTokens(ctx, user.ID)
+}
+
+func (s *service) ChangePassword(ctx context.Context, userID, sessionID string, req *ChangePasswordRequest) error {
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword))
+	if err != nil {
+		return ErrInvalidCredentials
+	}
+
+	err = s.setPassword(ctx, user, req.NewPassword)
+	if err != nil {
+		return err
+	}
+
+	// Keep the caller signed in, sign out everywhere else
+	return s.repo.RevokeOtherSessions(ctx, userID, sessionID)
+}
+
+func (s *service) RequestEmailChange(ctx context.Context, userID string, req *ChangeEmailRequest) error {
+	req.NewEmail = normalizeEmail(req.NewEmail)
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+	if err != nil {
+		return ErrInvalidCredentials
+	}
+
+	err = s.checkEmailDomain(req.NewEmail)
+	if err != nil {
+		return err
+	}
+
+	existingUser, err := s.repo.GetByEmail(ctx, req.NewEmail)
+	if err != nil {
+		return err
+	}
+	if existingUser != nil {
+		return ErrUserAlreadyExists
+	}
+
+	token, err := jwt.GenerateEmailChangeToken(user.ID, user.Email, req.NewEmail, s.jwtSecret, s.authConfig.EmailChangeTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	confirmLink := fmt.Sprintf("%s/confirm-email?token=%s", s.frontendHost, token)
+	body := fmt.Sprintf("Click here to confirm your new email address: <a href=\"%s\">Confirm Email</a>", confirmLink)
+
+	err = s.emailSender.Send(req.NewEmail, "Confirm Your New Email", body)
+	if err != nil {
+		return err
+	}
+
+	notice := fmt.Sprintf("A request was made to change your account email to %s. "+
+		"If this wasn't you, reset your password immediately.", req.NewEmail)
+
+	return s.emailSender.Send(user.Email, "Email Change Requested", notice)
+}
+
+func (s *service) ConfirmEmailChange(ctx context.Context, tokenString string) error {
+	claims, err := jwt.ValidateToken(tokenString, s.jwtSecret)
+	if err != nil || claims.Subject != jwt.SubjectEmailChange || claims.Email == "" || claims.PreviousEmail == "" {
+		return ErrInvalidToken
+	}
+
+	// Links sent before emails were normalized carry the address as typed
+	email := normalizeEmail(claims.Email)
+
+	user, err := s.repo.GetByID(ctx, claims.UserID)
 	if err != nil {
+		return err
+	}
+	if user == nil {
 		return ErrInvalidToken
 	}
+	if user.Email == email {
+		return nil // Already confirmed
+	}
+	// A link is spent once the email changes, so an older link can't switch
+	// the account back to an address the user moved away from
+	oldEmail := user.Email
+	if oldEmail != normalizeEmail(claims.PreviousEmail) {
+		return ErrInvalidToken
+	}
+
+	existingUser, err := s.repo.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if existingUser != nil {
+		return ErrUserAlreadyExists
+	}
+
+	err = s.repo.UpdateEmail(ctx, user.ID, email)
+	if err != nil {
+		return err
+	}
+
+	notice := fmt.Sprintf("Your account email was changed to %s. "+
+		"If this wasn't you, reset your password immediately.", html.EscapeString(email))
+
+	return s.emailSender.Send(oldEmail, "Email Changed", notice)
+}
+
+// setPassword is the single path for changing a password. It rejects the current
+// password and the last passwordPolicy.HistorySize ones.
+func (s *service) setPassword(ctx context.Context, user *User, newPassword string) error {
+	if err := s.checkPasswordReuse(ctx, user, newPassword); err != nil {
+		return err
+	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 
-	return s.repo.UpdatePassword(ctx, claims.UserID, string(hashedPassword))
+	return s.repo.UpdatePassword(ctx, user.ID, string(hashedPassword), s.passwordPolicy.HistorySize)
+}
+
+func (s *service) checkPasswordReuse(ctx context.Context, user *User, newPassword string) error {
+	if s.passwordPolicy.HistorySize <= 0 {
+		return nil
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(newPassword)) == nil {
+		return ErrPasswordReused
+	}
+
+	history, err := s.repo.GetPasswordHistory(ctx, user.ID, s.passwordPolicy.HistorySize)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range history {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(newPassword)) == nil {
+			return ErrPasswordReused
+		}
+	}
+
+	return nil
+}
+
+// mustResetPassword reports whether the user has to reset their password before
+// being issued tokens, either because it was flagged or because it is too old.
+func (s *service) mustResetPassword(user *User) bool {
+	if user.PasswordResetRequired {
+		return true
+	}
+	// Guests have no password to expire
+	if s.passwordPolicy.MaxAge <= 0 || user.IsGuest {
+		return false
+	}
+	return time.Since(user.PasswordChangedAt) > s.passwordPolicy.MaxAge
 }
@@ -0,0 +1,499 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"template/internal/config"
+	"template/internal/jwt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeRepo stores users in memory. Methods a test needs but fakeRepo does not
+// override panic through the nil embedded Repository.
+type fakeRepo struct {
+	Repository
+	users   map[string]*User
+	invites map[string]int
+	created []*User
+	// signedOut lists the users whose sessions were all revoked.
+	signedOut []string
+}
+
+func newFakeRepo(users ...*User) *fakeRepo {
+	r := &fakeRepo{users: make(map[string]*User), invites: make(map[string]int)}
+	for _, u := range users {
+		r.users[u.ID] = u
+	}
+	return r
+}
+
+func (r *fakeRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
+	for _, u := range r.users {
+		if u.Email == email && u.DeletedAt == nil {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) GetByID(ctx context.Context, id string) (*User, error) {
+	if u := r.users[id]; u != nil && u.DeletedAt == nil {
+		return u, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) GetDeletedByID(ctx context.Context, id string) (*User, error) {
+	if u := r.users[id]; u != nil && u.DeletedAt != nil {
+		return u, nil
+	}
+	return nil, nil
+}
+
+func (r *fakeRepo) SoftDeleteUser(ctx context.Context, userID string) error {
+	now := time.Now()
+	r.users[userID].DeletedAt = &now
+	return nil
+}
+
+func (r *fakeRepo) RestoreUser(ctx context.Context, userID string) (bool, error) {
+	u := r.users[userID]
+	if u == nil || u.DeletedAt == nil {
+		return false, nil
+	}
+	u.DeletedAt = nil
+	return true, nil
+}
+
+func (r *fakeRepo) DeleteUser(ctx context.Context, userID string) error {
+	delete(r.users, userID)
+	return nil
+}
+
+func (r *fakeRepo) FindSimilarUsername(ctx context.Context, username, exceptUserID string) (string, error) {
+	for _, u := range r.users {
+		if u.ID != exceptUserID && u.Username == username {
+			return u.Username, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *fakeRepo) Create(ctx context.Context, user *User) error {
+	user.ID = "new-user"
+	r.users[user.ID] = user
+	r.created = append(r.created, user)
+	return nil
+}
+
+func (r *fakeRepo) Register(ctx context.Context, user *User, inviteCode string, consents []Consent) error {
+	if inviteCode != "" {
+		if r.invites[inviteCode] == 0 {
+			return ErrInvalidInvite
+		}
+		r.invites[inviteCode]--
+	}
+	return r.Create(ctx, user)
+}
+
+func (r *fakeRepo) UpgradeGuest(ctx context.Context, userID, email, username, passwordHash string) (bool, error) {
+	u := r.users[userID]
+	u.Email, u.Username, u.PasswordHash, u.IsGuest = email, username, passwordHash, false
+	return true, nil
+}
+
+func (r *fakeRepo) ValidateInvite(ctx context.Context, code string) (bool, error) {
+	return r.invites[code] > 0, nil
+}
+
+func (r *fakeRepo) ConsumeInvite(ctx context.Context, code string) (bool, error) {
+	if r.invites[code] == 0 {
+		return false, nil
+	}
+	r.invites[code]--
+	return true, nil
+}
+
+func (r *fakeRepo) ReleaseInvite(ctx context.Context, code string) error {
+	r.invites[code]++
+	return nil
+}
+
+func (r *fakeRepo) ListRequiredLegalDocuments(ctx context.Context) ([]LegalDocument, error) {
+	return nil, nil
+}
+
+func (r *fakeRepo) CreateConsents(ctx context.Context, consents []Consent) error {
+	return nil
+}
+
+func (r *fakeRepo) GetRefreshToken(ctx context.Context, token string) (*RefreshToken, error) {
+	if token != "refresh" {
+		return nil, nil
+	}
+	return &RefreshToken{UserID: "admin", Token: token, ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (r *fakeRepo) ScheduleDeletion(ctx context.Context, userID string, at time.Time) error {
+	r.users[userID].DeletionScheduledAt = &at
+	return nil
+}
+
+func (r *fakeRepo) CancelDeletion(ctx context.Context, userID string, scheduledAt time.Time) (bool, error) {
+	u := r.users[userID]
+	if u == nil || u.DeletionScheduledAt == nil || !u.DeletionScheduledAt.Truncate(time.Second).Equal(scheduledAt) {
+		return false, nil
+	}
+	u.DeletionScheduledAt = nil
+	return true, nil
+}
+
+func (r *fakeRepo) RevokeAllUserTokens(ctx context.Context, userID string) error {
+	r.signedOut = append(r.signedOut, userID)
+	return nil
+}
+
+func (r *fakeRepo) RevokeRefreshToken(ctx context.Context, token string) error {
+	return nil
+}
+
+func (r *fakeRepo) SetPasswordResetRequired(ctx context.Context, userID string) error {
+	r.users[userID].PasswordResetRequired = true
+	return nil
+}
+
+func (r *fakeRepo) UpdatePassword(ctx context.Context, userID, passwordHash string, historySize int) error {
+	r.users[userID].PasswordHash = passwordHash
+	return nil
+}
+
+func (r *fakeRepo) UpdateEmail(ctx context.Context, userID, email string) error {
+	r.users[userID].Email = email
+	return nil
+}
+
+func (r *fakeRepo) CreateLoginEvent(ctx context.Context, event *LoginEvent) error {
+	return nil
+}
+
+type sentEmail struct {
+	to, subject, body string
+}
+
+// fakeMailer records emails instead of sending them, failing for the
+// addresses in failTo.
+type fakeMailer struct {
+	sent   []sentEmail
+	failTo map[string]bool
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	if m.failTo[to] {
+		return errors.New("mailbox unavailable")
+	}
+	m.sent = append(m.sent, sentEmail{to, subject, body})
+	return nil
+}
+
+func newTestService(repo Repository, mailer *fakeMailer, authConfig config.AuthConfig) *service {
+	return NewService(repo, "secret", mailer, "https://app.example.com", config.PasswordConfig{}, authConfig, nil, nil).(*service)
+}
+
+func existingUser(t *testing.T) *User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &User{ID: "existing", Email: "taken@example.com", Username: "taken", PasswordHash: string(hash), Role: RoleUser}
+}
+
+func TestRegisterEnumerationProtection(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         RegisterRequest
+		wantTo      string
+		wantCreated bool
+	}{
+		{
+			name:        "new email",
+			req:         RegisterRequest{Email: "new@example.com", Username: "newcomer", Password: "password123"},
+			wantTo:      "new@example.com",
+			wantCreated: true,
+		},
+		{
+			name:   "taken email",
+			req:    RegisterRequest{Email: "Taken@Example.com", Username: "newcomer", Password: "password123"},
+			wantTo: "taken@example.com",
+		},
+		{
+			name:   "taken username",
+			req:    RegisterRequest{Email: "new@example.com", Username: "Taken", Password: "password123"},
+			wantTo: "new@example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepo(existingUser(t))
+			mailer := &fakeMailer{}
+			s := newTestService(repo, mailer, config.AuthConfig{EnumerationProtection: true})
+
+			tokens, err := s.Register(context.Background(), &tt.req, ClientInfo{})
+			if err != nil || tokens != nil {
+				t.Fatalf("Register() = %v, %v; want nil, nil", tokens, err)
+			}
+			if len(mailer.sent) != 1 || mailer.sent[0].to != tt.wantTo {
+				t.Fatalf("sent %+v; want one email to %s", mailer.sent, tt.wantTo)
+			}
+			if created := len(repo.created) > 0; created != tt.wantCreated {
+				t.Errorf("created = %v; want %v", created, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestRegisterWithoutEnumerationProtection(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     RegisterRequest
+		wantErr error
+	}{
+		{"taken email", RegisterRequest{Email: "taken@example.com", Username: "newcomer", Password: "password123"}, ErrUserAlreadyExists},
+		{"taken username", RegisterRequest{Email: "new@example.com", Username: "taken", Password: "password123"}, ErrUsernameTaken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailer := &fakeMailer{}
+			s := newTestService(newFakeRepo(existingUser(t)), mailer, config.AuthConfig{})
+
+			_, err := s.Register(context.Background(), &tt.req, ClientInfo{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register() error = %v; want %v", err, tt.wantErr)
+			}
+			if len(mailer.sent) != 0 {
+				t.Errorf("sent %+v; want no email", mailer.sent)
+			}
+		})
+	}
+}
+
+func TestRegisterInviteIsCheckedBeforeEmail(t *testing.T) {
+	authConfig := config.AuthConfig{
+		EnumerationProtection: true,
+		Registration:          config.RegistrationConfig{Mode: config.RegistrationInvite},
+	}
+
+	for _, email := range []string{"taken@example.com", "new@example.com"} {
+		repo := newFakeRepo(existingUser(t))
+		repo.invites["valid"] = 1
+		s := newTestService(repo, &fakeMailer{}, authConfig)
+
+		_, err := s.Register(context.Background(), &RegisterRequest{Email: email, Username: "newcomer", Password: "password123", InviteCode: "bogus"}, ClientInfo{})
+		if err != ErrInvalidInvite {
+			t.Errorf("Register(%s) with bogus invite error = %v; want %v", email, err, ErrInvalidInvite)
+		}
+	}
+
+	// A taken email must not use up the invite
+	repo := newFakeRepo(existingUser(t))
+	repo.invites["valid"] = 1
+	s := newTestService(repo, &fakeMailer{}, authConfig)
+
+	_, err := s.Register(context.Background(), &RegisterRequest{Email: "taken@example.com", Username: "newcomer", Password: "password123", InviteCode: "valid"}, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repo.invites["valid"] != 1 {
+		t.Errorf("invite uses left = %d; want 1", repo.invites["valid"])
+	}
+}
+
+func TestUpgradeGuestEnumerationProtection(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    UpgradeRequest
+		wantTo string
+		guest  bool
+	}{
+		{"new email", UpgradeRequest{Email: "new@example.com", Password: "password123"}, "new@example.com", false},
+		{"taken email", UpgradeRequest{Email: "taken@example.com", Password: "password123"}, "taken@example.com", true},
+		{"taken username", UpgradeRequest{Email: "new@example.com", Username: "taken", Password: "password123"}, "new@example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guest := &User{ID: "guest", Username: "guest_1234", IsGuest: true, Role: RoleUser}
+			mailer := &fakeMailer{}
+			s := newTestService(newFakeRepo(existingUser(t), guest), mailer, config.AuthConfig{EnumerationProtection: true})
+
+			upgraded, err := s.UpgradeGuest(context.Background(), guest.ID, &tt.req)
+			if err != nil || upgraded {
+				t.Fatalf("UpgradeGuest() = %v, %v; want false, nil", upgraded, err)
+			}
+			if len(mailer.sent) != 1 || mailer.sent[0].to != tt.wantTo {
+				t.Fatalf("sent %+v; want one email to %s", mailer.sent, tt.wantTo)
+			}
+			if guest.IsGuest != tt.guest {
+				t.Errorf("IsGuest = %v; want %v", guest.IsGuest, tt.guest)
+			}
+		})
+	}
+}
+
+func TestLoginComparesPasswordForUnknownEmail(t *testing.T) {
+	if cost, err := bcrypt.Cost(dummyPasswordHash); err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("dummy hash cost = %d, %v; want %d", cost, err, bcrypt.DefaultCost)
+	}
+
+	compared := 0
+	defer func(orig func([]byte, []byte) error) { comparePassword = orig }(comparePassword)
+	comparePassword = func(hash, password []byte) error {
+		compared++
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+
+	s := newTestService(newFakeRepo(existingUser(t)), &fakeMailer{}, config.AuthConfig{})
+	for _, email := range []string{"unknown@example.com", "taken@example.com"} {
+		compared = 0
+		_, err := s.Login(context.Background(), &LoginRequest{Email: email, Password: "wrong-password"}, ClientInfo{})
+		if err != ErrInvalidCredentials {
+			t.Errorf("Login(%s) error = %v; want %v", email, err, ErrInvalidCredentials)
+		}
+		if compared != 1 {
+			t.Errorf("Login(%s) compared %d passwords; want 1", email, compared)
+		}
+	}
+}
+
+func TestLoginRejectsUserWithoutPassword(t *testing.T) {
+	var hashes [][]byte
+	defer func(orig func([]byte, []byte) error) { comparePassword = orig }(comparePassword)
+	comparePassword = func(hash, password []byte) error {
+		hashes = append(hashes, hash)
+		return nil
+	}
+
+	imported := &User{ID: "imported", Email: "imported@example.com", Username: "imported", Role: RoleUser}
+	s := newTestService(newFakeRepo(imported), &fakeMailer{}, config.AuthConfig{})
+
+	_, err := s.Login(context.Background(), &LoginRequest{Email: imported.Email, Password: "dummy-password-for-timing"}, ClientInfo{})
+	if err != ErrInvalidCredentials {
+		t.Errorf("Login() error = %v; want %v", err, ErrInvalidCredentials)
+	}
+	if len(hashes) != 1 || !bytes.Equal(hashes[0], dummyPasswordHash) {
+		t.Errorf("compared against %q; want only the dummy hash", hashes)
+	}
+}
+
+func TestCheckReservedUsernameMatchesLookalikes(t *testing.T) {
+	s := newTestService(newFakeRepo(), &fakeMailer{}, config.AuthConfig{
+		Registration: config.RegistrationConfig{ReservedUsernames: []string{"Billing"}},
+	})
+
+	for _, username := range []string{"root", "r00t", "adm1n", "supp0rt", "no.reply", "nо_rеply", "bi11ing"} {
+		if err := s.checkReservedUsername(username); err != ErrUsernameReserved {
+			t.Errorf("checkReservedUsername(%q) = %v; want %v", username, err, ErrUsernameReserved)
+		}
+	}
+	for _, username := range []string{"rooted", "admins", "billy"} {
+		if err := s.checkReservedUsername(username); err != nil {
+			t.Errorf("checkReservedUsername(%q) = %v; want nil", username, err)
+		}
+	}
+}
+
+func TestIntrospectTokenReportsScope(t *testing.T) {
+	admin := &User{ID: "admin", Email: "admin@example.com", Username: "admin", Role: RoleAdmin}
+	s := newTestService(newFakeRepo(admin), &fakeMailer{}, config.AuthConfig{})
+
+	access, err := jwt.GenerateTokens(admin.ID, s.jwtSecret, jwt.TokenOptions{Role: admin.Role, Scope: scopeFor(admin.Role), TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{access.AccessToken, "refresh"} {
+		result, err := s.IntrospectToken(context.Background(), token, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Active || result.Scope != "user admin" {
+			t.Errorf("IntrospectToken() = %+v; want active with scope %q", result, "user admin")
+		}
+	}
+}
+
+func TestConfirmEmailChangeLinkIsSpentByLaterChange(t *testing.T) {
+	u := existingUser(t)
+	mailer := &fakeMailer{}
+	s := newTestService(newFakeRepo(u), mailer, config.AuthConfig{})
+	ctx := context.Background()
+
+	toB, err := jwt.GenerateEmailChangeToken(u.ID, "taken@example.com", "b@example.com", s.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toC, err := jwt.GenerateEmailChangeToken(u.ID, "b@example.com", "c@example.com", s.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.ConfirmEmailChange(ctx, toB); err != nil || u.Email != "b@example.com" {
+		t.Fatalf("ConfirmEmailChange(to b) = %v, email %s", err, u.Email)
+	}
+	if len(mailer.sent) != 1 || mailer.sent[0].to != "taken@example.com" {
+		t.Errorf("sent %+v; want a notice to the old address", mailer.sent)
+	}
+	if err = s.ConfirmEmailChange(ctx, toC); err != nil || u.Email != "c@example.com" {
+		t.Fatalf("ConfirmEmailChange(to c) = %v, email %s", err, u.Email)
+	}
+
+	if err = s.ConfirmEmailChange(ctx, toB); err != ErrInvalidToken {
+		t.Errorf("ConfirmEmailChange(to b) again error = %v; want %v", err, ErrInvalidToken)
+	}
+	if u.Email != "c@example.com" {
+		t.Errorf("email = %s; want c@example.com", u.Email)
+	}
+}
+
+func TestReportSessionSignsOutEverywhere(t *testing.T) {
+	u := existingUser(t)
+	u.ID = "admin" // Owns the fake repo's "refresh" token
+	repo := newFakeRepo(u)
+	mailer := &fakeMailer{}
+	s := newTestService(repo, mailer, config.AuthConfig{})
+	ctx := context.Background()
+
+	report, err := jwt.GenerateSessionReportToken(u.ID, "attacker-session", s.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ReportSession(ctx, report); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.signedOut) != 1 || repo.signedOut[0] != u.ID {
+		t.Errorf("signed out %v; want all of %s's sessions", repo.signedOut, u.ID)
+	}
+
+	// A session the report did not name can't be refreshed either
+	if _, err = s.RefreshToken(ctx, "refresh", ClientInfo{}); err != ErrPasswordExpired {
+		t.Errorf("RefreshToken() error = %v; want %v", err, ErrPasswordExpired)
+	}
+
+	reset, err := jwt.GenerateResetToken(u.ID, s.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.ResetPassword(ctx, reset, "new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.signedOut) != 2 {
+		t.Errorf("signed out %v after reset; want a second sign-out", repo.signedOut)
+	}
+}
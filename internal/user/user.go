@@ -4,31 +4,174 @@ import (
 	"time"
 )
 
+// Roles.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Scopes granted to tokens, reported by token introspection. Every user gets
+// ScopeUser; admins get ScopeAdmin as well.
+const (
+	ScopeUser  = "user"
+	ScopeAdmin = "admin"
+)
+
+// scopeFor returns the space-separated scopes for a role.
+func scopeFor(role string) string {
+	if role == RoleAdmin {
+		return ScopeUser + " " + ScopeAdmin
+	}
+	return ScopeUser
+}
+
 type User struct {
-	ID           string     `db:"id" json:"id"`
-	Email        string     `db:"email" json:"email"`
-	Username     string     `db:"username" json:"username"`
-	PasswordHash string     `db:"password_hash" json:"-"`
-	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
-	LastLogin    *time.Time `db:"last_login" json:"last_login,omitempty"`
+	ID                    string     `db:"id" json:"id"`
+	Email                 string     `db:"email" json:"email"`
+	Username              string     `db:"username" json:"username"`
+	DisplayName           string     `db:"display_name" json:"display_name"`
+	Bio                   string     `db:"bio" json:"bio"`
+	Locale                string     `db:"locale" json:"locale"`
+	Timezone              string     `db:"timezone" json:"timezone"`
+	ProfileVisibility     string     `db:"profile_visibility" json:"profile_visibility"`
+	Role                  string     `db:"role" json:"role"`
+	PasswordHash          string     `db:"password_hash" json:"-"`
+	PasswordChangedAt     time.Time  `db:"password_changed_at" json:"-"`
+	PasswordResetRequired bool       `db:"password_reset_required" json:"-"`
+	IsGuest               bool       `db:"is_guest" json:"is_guest"`
+	CreatedAt             time.Time  `db:"created_at" json:"created_at"`
+	LastLogin             *time.Time `db:"last_login" json:"last_login,omitempty"`
+	DeletionScheduledAt   *time.Time `db:"deletion_scheduled_at" json:"deletion_scheduled_at,omitempty"`
+	SuspendedAt           *time.Time `db:"suspended_at" json:"suspended_at,omitempty"`
+	AvatarKey             string     `db:"avatar_key" json:"-"`
+	DeletedAt             *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+	// AvatarURL and AvatarThumbnails are resolved from AvatarKey by handlers.
+	AvatarURL        string            `db:"-" json:"avatar_url,omitempty"`
+	AvatarThumbnails map[string]string `db:"-" json:"avatar_thumbnails,omitempty"`
 }
 
 type RegisterRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Password string `json:"password" validate:"required,min=8"`
+	// InviteCode is required when registration is invite-only.
+	InviteCode string `json:"invite_code,omitempty"`
+	// Consents are the IDs of the legal documents the user accepted. Every
+	// current mandatory document must be included.
+	Consents []string `json:"consents" validate:"omitempty,dive,uuid"`
+}
+
+// UpgradeRequest turns a guest account into a full account, keeping its ID and
+// data. The generated guest username is kept when Username is empty.
+type UpgradeRequest struct {
+	Email      string `json:"email" validate:"required,email"`
+	Username   string `json:"username" validate:"omitempty,min=3,max=50"`
+	Password   string `json:"password" validate:"required,min=8"`
+	InviteCode string `json:"invite_code,omitempty"`
 }
 
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
+	// RememberMe selects the long session policy.
+	RememberMe bool `json:"remember_me"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8"`
+}
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ClientInfo describes the client making an authentication request.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	// JKT is the thumbprint of the key from a verified DPoP proof, if any.
+	JKT string
+}
+
+// Login methods recorded in login_events.
+const (
+	LoginMethodPassword = "password"
+	LoginMethodRefresh  = "refresh"
+	LoginMethodRegister = "register"
+	LoginMethodDevice   = "device"
+	LoginMethodGuest    = "guest"
+)
+
+type LoginEvent struct {
+	ID        string    `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"-"`
+	IP        string    `db:"ip" json:"ip"`
+	UserAgent string    `db:"user_agent" json:"user_agent"`
+	Method    string    `db:"method" json:"method"`
+	Success   bool      `db:"success" json:"success"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type PageQuery struct {
+	Page    int `query:"page" validate:"omitempty,min=1"`
+	PerPage int `query:"per_page" validate:"omitempty,min=1,max=100"`
+}
+
+// Normalize fills in defaults for omitted values.
+func (q *PageQuery) Normalize() {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PerPage == 0 {
+		q.PerPage = 20
+	}
+}
+
+func (q PageQuery) Offset() int {
+	return (q.Page - 1) * q.PerPage
+}
+
+// Token type hints accepted by introspection and revocation (RFC 7009).
+const (
+	TokenHintAccessToken  = "access_token"
+	TokenHintRefreshToken = "refresh_token"
+)
+
+// TokenIntrospection is an RFC 7662 introspection result. Inactive tokens only
+// report Active.
+type TokenIntrospection struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+}
+
+// Session summarizes the chain of refresh tokens of one login.
+type Session struct {
+	ID              string    `db:"session_id" json:"id"`
+	StartedAt       time.Time `db:"started_at" json:"started_at"`
+	LastRefreshedAt time.Time `db:"last_refreshed_at" json:"last_refreshed_at"`
+	ExpiresAt       time.Time `db:"expires_at" json:"expires_at"`
+	Ended           bool      `db:"ended" json:"ended"`
+	RememberMe      bool      `db:"remember_me" json:"remember_me"`
 }
 
 type RefreshToken struct {
 	ID        string    `db:"id"`
 	UserID    string    `db:"user_id"`
+	SessionID string    `db:"session_id"`
 	Token     string    `db:"token"`
+	JKT       string    `db:"jkt"`
 	ExpiresAt time.Time `db:"expires_at"`
 	CreatedAt time.Time `db:"created_at"`
 	Revoked   bool      `db:"revoked"`
+	// SessionExpiresAt is the absolute deadline of the session, ExpiresAt never
+	// goes past it.
+	SessionExpiresAt time.Time `db:"session_expires_at"`
+	RememberMe       bool      `db:"remember_me"`
 }
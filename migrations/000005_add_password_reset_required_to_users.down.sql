@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_login_events_user_device;
+ALTER TABLE users DROP COLUMN IF EXISTS password_reset_required;
@@ -0,0 +1,4 @@
+-- Set when a user reports a sign-in as not theirs; cleared by the next password change
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_login_events_user_device ON login_events(user_id, ip, user_agent) WHERE success;
@@ -0,0 +1,2 @@
+-- DPoP key thumbprint a refresh token is bound to (empty when unbound)
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS jkt VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS remember_me;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS session_expires_at;
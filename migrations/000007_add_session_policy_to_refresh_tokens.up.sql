@@ -0,0 +1,6 @@
+-- Absolute session deadline and policy, carried over on every rotation
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_expires_at TIMESTAMP;
+UPDATE refresh_tokens SET session_expires_at = expires_at WHERE session_expires_at IS NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN session_expires_at SET NOT NULL;
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS remember_me BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+DELETE FROM users WHERE is_guest;
+DROP INDEX IF EXISTS idx_users_guest_activity;
+ALTER TABLE users DROP COLUMN IF EXISTS is_guest;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
@@ -0,0 +1,5 @@
+-- Guests have no email until they upgrade
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_guest BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_guest_activity ON users(COALESCE(last_login, created_at)) WHERE is_guest;
@@ -0,0 +1,24 @@
+-- Versioned legal documents such as terms of service and privacy policy
+CREATE TABLE IF NOT EXISTS legal_documents (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    type VARCHAR(30) NOT NULL,
+    version VARCHAR(30) NOT NULL,
+    url TEXT NOT NULL,
+    mandatory BOOLEAN NOT NULL DEFAULT TRUE,
+    published_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (type, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_legal_documents_type ON legal_documents(type, published_at DESC);
+
+-- Create consents table
+CREATE TABLE IF NOT EXISTS consents (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    document_id UUID NOT NULL REFERENCES legal_documents(id) ON DELETE RESTRICT,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    accepted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, document_id)
+);
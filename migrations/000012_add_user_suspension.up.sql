@@ -0,0 +1,2 @@
+-- Suspended users cannot log in or refresh
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP;
@@ -0,0 +1,2 @@
+-- Whether GET /users/{username} shows the user's public profile
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_visibility VARCHAR(20) NOT NULL DEFAULT 'public';
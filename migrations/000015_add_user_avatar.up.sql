@@ -0,0 +1,2 @@
+-- Storage key prefix of the current avatar and its thumbnails
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_key VARCHAR(255) NOT NULL DEFAULT '';
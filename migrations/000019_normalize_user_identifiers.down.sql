@@ -0,0 +1,10 @@
+-- Normalized emails and usernames, and accounts soft-deleted as duplicates,
+-- are left as they are
+DROP INDEX IF EXISTS idx_users_username_skeleton;
+DROP INDEX IF EXISTS idx_users_username;
+DROP INDEX IF EXISTS idx_users_email;
+
+DROP FUNCTION IF EXISTS username_skeleton(TEXT);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(email) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username) WHERE deleted_at IS NULL;
@@ -0,0 +1,57 @@
+-- Emails and usernames are stored NFKC normalized and lowercased, usernames
+-- fully case folded, by the application. lower() serves as the database-level
+-- equivalent: it only differs from folding in a few characters such as "ß".
+
+-- username_skeleton maps characters that look alike, such as Cyrillic "а" and
+-- Latin "a" or "0" and "o", to one of them, so that "pаypal" and "paypal" cannot
+-- both be taken
+CREATE OR REPLACE FUNCTION username_skeleton(username TEXT) RETURNS TEXT
+LANGUAGE SQL IMMUTABLE STRICT PARALLEL SAFE
+AS $$
+    SELECT translate(lower(normalize(username, NFKC)), 'аеорсухіјѕԁһԛԝӏαοινρυχγɑɡı01-.', 'aeopcyxijsdhqwlaoivpuxyagiol__')
+$$;
+
+DROP INDEX IF EXISTS idx_users_email;
+DROP INDEX IF EXISTS idx_users_username;
+
+-- Accounts whose emails only differ in case or Unicode form belong to the same
+-- person. The most recently active one is kept and the others are soft-deleted,
+-- so admins can find them with ?status=deleted and restore or purge them.
+UPDATE users SET deleted_at = CURRENT_TIMESTAMP
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, row_number() OVER (
+            PARTITION BY lower(normalize(email, NFKC))
+            ORDER BY COALESCE(last_login, created_at) DESC, created_at, id
+        ) AS rank
+        FROM users
+        WHERE email IS NOT NULL AND deleted_at IS NULL
+    ) ranked
+    WHERE rank > 1
+);
+
+UPDATE refresh_tokens SET revoked = TRUE
+WHERE user_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL);
+
+-- Of usernames that collide once normalized, or only differ by lookalike
+-- characters, the oldest keeps its name and the others get part of their ID
+-- appended. Users can choose a new username afterwards.
+UPDATE users u
+SET username = left(lower(normalize(u.username, NFKC)), 41) || '_' || left(u.id::text, 8)
+FROM (
+    SELECT id, row_number() OVER (
+        PARTITION BY username_skeleton(username)
+        ORDER BY created_at, id
+    ) AS rank
+    FROM users
+    WHERE deleted_at IS NULL
+) ranked
+WHERE u.id = ranked.id AND ranked.rank > 1;
+
+UPDATE users SET
+    email = lower(normalize(email, NFKC)),
+    username = lower(normalize(username, NFKC));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users(lower(email)) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(lower(username)) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_skeleton ON users(username_skeleton(username)) WHERE deleted_at IS NULL;